@@ -358,12 +358,16 @@ app.listen(8080);
 // // 나중에 쓸 수 도?
 let details = '';
 const listener = async (event) => {
-  if (event.eventName === 'newReservation') {
-    console.log('\n\nnewReservation');
-    details = event.payload.toString('utf8');
-    // Run business process to handle orders
-    console.log('===============');
-    console.log(details);
+  // 모든 비즈니스 이벤트는 golfEvent 하나의 envelope 안에 담겨서 온다
+  if (event.eventName === 'golfEvent') {
+    const envelope = JSON.parse(event.payload.toString('utf8'));
+    for (const e of envelope.events) {
+      console.log(`\n\n${e.type} (schema v${envelope.schemaVersion})`);
+      details = JSON.stringify(e.payload);
+      // Run business process to handle orders
+      console.log('===============');
+      console.log(details);
+    }
   }
 };
 
@@ -0,0 +1,288 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// dayFormat is the layout of a day of play, e.g. "2021-05-01"
+const dayFormat = "2006-01-02"
+
+// maxAnalyticsDays limits the days of one analytics page
+const maxAnalyticsDays = 31
+
+// AnalyticsFigures is the struct that aggregates the reservations of a period.
+// A no-show is a reservation that ended without being completed, and the no-show rate is
+// taken over the reservations that should have been played by now.
+// Occupancy is the share of the opening hours held by reservations, from 0 to 1.
+// Revenue sums the stamped quotes of the reservations that were not cancelled.
+type AnalyticsFigures struct {
+	Reservations     uint    `json:"reservations"`
+	Cancelled        uint    `json:"cancelled"`
+	Completed        uint    `json:"completed"`
+	NoShows          uint    `json:"noShows"`
+	Players          uint    `json:"players"`
+	Occupancy        float64 `json:"occupancy"`
+	NoShowRate       float64 `json:"noShowRate"`
+	AverageLeadHours float64 `json:"averageLeadHours"`
+	Revenue          uint    `json:"revenue"`
+}
+
+// BandOccupancy is the struct that describes the occupancy of an hour of a day
+type BandOccupancy struct {
+	StartHour uint    `json:"startHour"`
+	EndHour   uint    `json:"endHour"`
+	Occupancy float64 `json:"occupancy"`
+}
+
+// DayAnalytics is the struct that describes the figures of one day and the occupancy of its hours
+type DayAnalytics struct {
+	Day     string            `json:"day"`
+	Figures *AnalyticsFigures `json:"figures"`
+	Bands   []*BandOccupancy  `json:"bands"`
+}
+
+// GroundAnalytics is the struct that returns a page of days and the figures of the whole page.
+// Pass Bookmark to the next call to read the following days; it is empty after the last day.
+type GroundAnalytics struct {
+	GroundID string            `json:"groundID"`
+	Currency string            `json:"currency"`
+	Days     []*DayAnalytics   `json:"days"`
+	Summary  *AnalyticsFigures `json:"summary"`
+	Bookmark string            `json:"bookmark"`
+}
+
+// analyticsTally collects the sums behind AnalyticsFigures
+type analyticsTally struct {
+	figures     AnalyticsFigures
+	openMinutes uint
+	heldMinutes uint
+	leadHours   float64
+	leadCount   uint
+}
+
+// QueryGroundAnalytics is the query function that returns the occupancy, no-shows, lead time and revenue of a ground by day
+// params - groundID, first and last day (2006-01-02) of the range, days per page(at most 31), bookmark of the previous page
+// returns the GroundAnalytics
+func (s *SmartContract) QueryGroundAnalytics(ctx contractapi.TransactionContextInterface, groundID string, from string, to string, pageSize int32, bookmark string) (*GroundAnalytics, error) {
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return nil, newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	firstDay, err := time.Parse(dayFormat, from)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "from must be a day like 2006-01-02")
+	}
+	lastDay, err := time.Parse(dayFormat, to)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "to must be a day like 2006-01-02")
+	}
+	if lastDay.Before(firstDay) {
+		return nil, newBookingError(ErrCodeInvalidRequest, "to must not be before from")
+	}
+
+	if pageSize <= 0 || pageSize > maxAnalyticsDays {
+		return nil, newBookingError(ErrCodeInvalidRequest, "pageSize must be from 1 to %d", maxAnalyticsDays)
+	}
+
+	if bookmark != "" {
+		firstDay, err = time.Parse(dayFormat, bookmark)
+		if err != nil {
+			return nil, newBookingError(ErrCodeInvalidRequest, "bookmark %s is not valid", bookmark)
+		}
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rateTable, err := getRateTable(ctx, groundID)
+	if err != nil {
+		return nil, err
+	}
+
+	analytics := &GroundAnalytics{
+		GroundID: groundID,
+		Days:     []*DayAnalytics{},
+	}
+	if rateTable != nil {
+		analytics.Currency = rateTable.Currency
+	}
+
+	summary := new(analyticsTally)
+	day := firstDay
+	for i := int32(0); i < pageSize && !day.After(lastDay); i++ {
+		reservations, err := queryDayReservations(ctx, groundID, day.Format(dayFormat))
+		if err != nil {
+			return nil, err
+		}
+
+		dayAnalytics, tally := analyzeDay(ground, day, reservations, txTime)
+		analytics.Days = append(analytics.Days, dayAnalytics)
+		summary.add(tally)
+
+		day = day.AddDate(0, 0, 1)
+	}
+
+	if !day.After(lastDay) {
+		analytics.Bookmark = day.Format(dayFormat)
+	}
+	analytics.Summary = summary.result()
+
+	return analytics, nil
+}
+
+// queryDayReservations returns the reservations of the ground played on the day.
+// An archived reservation is rebuilt from its summary.
+func queryDayReservations(ctx contractapi.TransactionContextInterface, groundID string, day string) ([]*Reservation, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("groundDayReservation", []string{groundID, day})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var reservations []*Reservation
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		reservationAsBytes, err := ctx.GetStub().GetState(string(queryResponse.Value))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+		}
+
+		if reservationAsBytes == nil {
+			continue
+		}
+
+		if isArchiveKey(ctx, string(queryResponse.Value)) {
+			archived := new(ArchivedReservation)
+			err = json.Unmarshal(reservationAsBytes, archived)
+			if err != nil {
+				return nil, fmt.Errorf("archivedReservation Unmarshal Error: %s", err.Error())
+			}
+
+			reservations = append(reservations, archived.reservation())
+			continue
+		}
+
+		reservation, err := unmarshalReservation(reservationAsBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		reservations = append(reservations, reservation)
+	}
+
+	return reservations, nil
+}
+
+// analyzeDay aggregates the reservations of a day.
+// The opening hours are counted by the minute, so overlapping block groups hold a minute only once.
+func analyzeDay(ground *Ground, day time.Time, reservations []*Reservation, txTime time.Time) (*DayAnalytics, *analyticsTally) {
+	tally := new(analyticsTally)
+	held := make([]bool, 24*60)
+
+	for _, reservation := range reservations {
+		if reservation.currentStatus() == StatusCancelled {
+			tally.figures.Cancelled++
+			continue
+		}
+
+		tally.figures.Reservations++
+		tally.figures.Players += reservation.Players
+
+		switch {
+		case reservation.currentStatus() == StatusCompleted:
+			tally.figures.Completed++
+		case reservation.End.Before(txTime):
+			tally.figures.NoShows++
+		}
+
+		if len(reservation.History) > 0 {
+			tally.leadHours += reservation.Begin.Sub(reservation.History[0].Timestamp).Hours()
+			tally.leadCount++
+		}
+
+		if reservation.Quote != nil {
+			tally.figures.Revenue += reservation.Quote.Total
+		}
+
+		// the minutes are counted on the clock the reservation was made in
+		beginMinute := reservation.Begin.Hour()*60 + reservation.Begin.Minute()
+		endMinute := beginMinute + int(reservation.End.Sub(reservation.Begin).Minutes())
+		for minute := beginMinute; minute < endMinute && minute < len(held); minute++ {
+			held[minute] = true
+		}
+	}
+
+	dayAnalytics := &DayAnalytics{
+		Day:   day.Format(dayFormat),
+		Bands: []*BandOccupancy{},
+	}
+
+	for hour := ground.AvailableTimeStart; hour < ground.AvailableTimeEnd && hour < 24; hour++ {
+		var heldMinutes uint
+		for minute := hour * 60; minute < (hour+1)*60; minute++ {
+			if held[minute] {
+				heldMinutes++
+			}
+		}
+
+		tally.openMinutes += 60
+		tally.heldMinutes += heldMinutes
+		dayAnalytics.Bands = append(dayAnalytics.Bands, &BandOccupancy{
+			StartHour: hour,
+			EndHour:   hour + 1,
+			Occupancy: float64(heldMinutes) / 60,
+		})
+	}
+
+	dayAnalytics.Figures = tally.result()
+
+	return dayAnalytics, tally
+}
+
+// add sums another tally into this one
+func (t *analyticsTally) add(other *analyticsTally) {
+	t.figures.Reservations += other.figures.Reservations
+	t.figures.Cancelled += other.figures.Cancelled
+	t.figures.Completed += other.figures.Completed
+	t.figures.NoShows += other.figures.NoShows
+	t.figures.Players += other.figures.Players
+	t.figures.Revenue += other.figures.Revenue
+	t.openMinutes += other.openMinutes
+	t.heldMinutes += other.heldMinutes
+	t.leadHours += other.leadHours
+	t.leadCount += other.leadCount
+}
+
+// result computes the rates and averages of the tally
+func (t *analyticsTally) result() *AnalyticsFigures {
+	figures := t.figures
+
+	if t.openMinutes > 0 {
+		figures.Occupancy = float64(t.heldMinutes) / float64(t.openMinutes)
+	}
+	if played := figures.Completed + figures.NoShows; played > 0 {
+		figures.NoShowRate = float64(figures.NoShows) / float64(played)
+	}
+	if t.leadCount > 0 {
+		figures.AverageLeadHours = t.leadHours / float64(t.leadCount)
+	}
+
+	return &figures
+}
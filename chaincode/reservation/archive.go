@@ -0,0 +1,441 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ArchivedReservation is the struct that summarizes a reservation removed from the world state.
+// Key is the composite key the reservation was stored under, whose history on the blockchain
+// still holds every version of it; QueryArchivedHistory reads them back.
+// The indexes by number and by day point at the summary, so the analytics still count the round.
+type ArchivedReservation struct {
+	GroundID          string    `json:"groundID"`
+	ReservationNumber string    `json:"reservationNumber"`
+	UserID            string    `json:"userID"`
+	Begin             time.Time `json:"begin"`
+	End               time.Time `json:"end"`
+	Players           uint      `json:"players"`
+	Status            string    `json:"status"`
+	Currency          string    `json:"currency,omitempty" metadata:"currency,optional"`
+	Total             uint      `json:"total"`
+	BookedAt          time.Time `json:"bookedAt"`
+	Key               string    `json:"key"`
+	ArchivedTxID      string    `json:"archivedTxID"`
+	SchemaVersion     uint      `json:"schemaVersion"`
+}
+
+// ArchiveReport is the struct that reports the progress of ArchiveReservations over a page of days.
+// Unsettled counts the reservations kept because a participant still owes their share.
+type ArchiveReport struct {
+	GroundID  string `json:"groundID"`
+	Scanned   uint   `json:"scanned"`
+	Archived  uint   `json:"archived"`
+	Unsettled uint   `json:"unsettled"`
+	Bookmark  string `json:"bookmark"`
+	Done      bool   `json:"done"`
+}
+
+// ArchivePage is the struct that returns a page of archived reservations.
+// Pass Bookmark to the next call to read the following page; it is empty after the last page.
+type ArchivePage struct {
+	Reservations []*ArchivedReservation `json:"reservations"`
+	Bookmark     string                 `json:"bookmark"`
+}
+
+// ArchiveReservations is the operator invoke function that moves the completed and cancelled reservations
+// of a ground that ended before the cutoff out of the world state, leaving an ArchivedReservation for each.
+// The reservations are read from the index by day, pageSize days at a time from the first day of play;
+// call it again with the returned bookmark, the next day, until the report is done.
+// A reservation with an unpaid share is kept until the share is settled or waived.
+// params - groundID, cutoff time in RFC3339, days per page(at most 31), bookmark of the previous page
+// returns the ArchiveReport
+func (s *SmartContract) ArchiveReservations(ctx contractapi.TransactionContextInterface, groundID string, cutoff string, pageSize int32, bookmark string) (*ArchiveReport, error) {
+	fmt.Println("ArchiveReservations called")
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return nil, newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireGroundOwner(ctx, ground)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoffTime, err := parseTime(cutoff)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "cutoff %s", err.Error())
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cutoffTime.After(txTime) {
+		return nil, newBookingError(ErrCodeInvalidRequest, "cutoff must not be in the future")
+	}
+
+	if pageSize <= 0 || pageSize > maxAnalyticsDays {
+		return nil, newBookingError(ErrCodeInvalidRequest, "pageSize must be from 1 to %d", maxAnalyticsDays)
+	}
+
+	day, found, err := firstArchiveDay(ctx, groundID, bookmark)
+	if err != nil {
+		return nil, err
+	}
+
+	// the days of play are in the offset of each reservation, so the day after the cutoff may still end before it
+	lastDay, _ := time.Parse(dayFormat, cutoffTime.AddDate(0, 0, 1).Format(dayFormat))
+
+	report := &ArchiveReport{
+		GroundID: groundID,
+	}
+
+	for i := int32(0); found && i < pageSize && !day.After(lastDay); i++ {
+		reservations, err := queryDayLiveReservations(ctx, groundID, day.Format(dayFormat))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, reservation := range reservations {
+			report.Scanned++
+
+			status := reservation.currentStatus()
+			if (status != StatusCompleted && status != StatusCancelled) || !reservation.End.Before(cutoffTime) {
+				continue
+			}
+
+			unsettled, err := hasUnpaidShares(ctx, reservation)
+			if err != nil {
+				return nil, err
+			}
+			if unsettled {
+				report.Unsettled++
+				continue
+			}
+
+			err = archiveReservation(ctx, reservation)
+			if err != nil {
+				return nil, err
+			}
+
+			report.Archived++
+		}
+
+		day = day.AddDate(0, 0, 1)
+	}
+
+	if found && !day.After(lastDay) {
+		report.Bookmark = day.Format(dayFormat)
+	}
+	report.Done = report.Bookmark == ""
+
+	return report, nil
+}
+
+// QueryArchivedReservations returns a page of the archived reservations of the ground
+// params - groundID, page size, bookmark of the previous page
+// returns the ArchivePage
+func (s *SmartContract) QueryArchivedReservations(ctx contractapi.TransactionContextInterface, groundID string, pageSize int32, bookmark string) (*ArchivePage, error) {
+	if pageSize <= 0 {
+		return nil, newBookingError(ErrCodeInvalidRequest, "pageSize must be greater than 0")
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination("archivedReservation", []string{groundID}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &ArchivePage{
+		Reservations: []*ArchivedReservation{},
+		Bookmark:     responseMetadata.Bookmark,
+	}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		archived := new(ArchivedReservation)
+		err = json.Unmarshal(queryResponse.Value, archived)
+		if err != nil {
+			return nil, fmt.Errorf("archivedReservation Unmarshal Error: %s", err.Error())
+		}
+
+		page.Reservations = append(page.Reservations, archived)
+	}
+
+	return page, nil
+}
+
+// QueryArchivedHistory is the query function that reads every version of an archived reservation from the blockchain
+// params - groundID, reservation number
+// returns the array of reservations, oldest first
+func (s *SmartContract) QueryArchivedHistory(ctx contractapi.TransactionContextInterface, groundID string, reservationNumber string) ([]*Reservation, error) {
+	archiveCompositeKey, _ := ctx.GetStub().CreateCompositeKey("archivedReservation", []string{groundID, reservationNumber})
+	archivedAsBytes, err := ctx.GetStub().GetState(archiveCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if archivedAsBytes == nil {
+		return nil, newBookingError(ErrCodeReservationNotFound, "%s is not archived", reservationNumber)
+	}
+
+	archived := new(ArchivedReservation)
+	err = json.Unmarshal(archivedAsBytes, archived)
+	if err != nil {
+		return nil, fmt.Errorf("archivedReservation Unmarshal Error: %s", err.Error())
+	}
+
+	historyIterator, err := ctx.GetStub().GetHistoryForKey(archived.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer historyIterator.Close()
+
+	var versions []*Reservation
+	blockTimes := make(map[*Reservation]int64)
+
+	for historyIterator.HasNext() {
+		modification, err := historyIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if modification.IsDelete {
+			continue
+		}
+
+		reservation, err := unmarshalReservation(modification.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		versions = append(versions, reservation)
+		blockTimes[reservation] = modification.Timestamp.GetSeconds()*int64(time.Second) + int64(modification.Timestamp.GetNanos())
+	}
+
+	// the order of the history differs between Fabric releases, so it is sorted by transaction time
+	sort.SliceStable(versions, func(i, j int) bool {
+		return blockTimes[versions[i]] < blockTimes[versions[j]]
+	})
+
+	return versions, nil
+}
+
+// firstArchiveDay returns the day ArchiveReservations starts from: the bookmark,
+// or the first day in the index by day of the ground
+// returns false when the ground has no reservation
+func firstArchiveDay(ctx contractapi.TransactionContextInterface, groundID string, bookmark string) (time.Time, bool, error) {
+	if bookmark != "" {
+		day, err := time.Parse(dayFormat, bookmark)
+		if err != nil {
+			return time.Time{}, false, newBookingError(ErrCodeInvalidRequest, "bookmark %s is not valid", bookmark)
+		}
+
+		return day, true, nil
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("groundDayReservation", []string{groundID})
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer resultsIterator.Close()
+
+	// the keys come in order, so the first one holds the first day
+	if !resultsIterator.HasNext() {
+		return time.Time{}, false, nil
+	}
+
+	queryResponse, err := resultsIterator.Next()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	day, err := time.Parse(dayFormat, attributes[1])
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%s is not a day of play. %s", attributes[1], err.Error())
+	}
+
+	return day, true, nil
+}
+
+// queryDayLiveReservations returns the reservations of the ground played on the day that are not archived yet
+func queryDayLiveReservations(ctx contractapi.TransactionContextInterface, groundID string, day string) ([]*Reservation, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("groundDayReservation", []string{groundID, day})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var reservations []*Reservation
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if isArchiveKey(ctx, string(queryResponse.Value)) {
+			continue
+		}
+
+		reservationAsBytes, err := ctx.GetStub().GetState(string(queryResponse.Value))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+		}
+
+		if reservationAsBytes == nil {
+			continue
+		}
+
+		reservation, err := unmarshalReservation(reservationAsBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		reservations = append(reservations, reservation)
+	}
+
+	return reservations, nil
+}
+
+// hasUnpaidShares reports whether a participant of the reservation still owes the holder their share
+func hasUnpaidShares(ctx contractapi.TransactionContextInterface, reservation *Reservation) (bool, error) {
+	for _, participant := range reservation.Participants {
+		share, err := getShare(ctx, reservation.GroundID, reservation.ReservationNumber, participant)
+		if err != nil {
+			return false, err
+		}
+
+		if share != nil && share.Status == ShareUnpaid {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// isArchiveKey reports whether an index points at the summary of an archived reservation
+func isArchiveKey(ctx contractapi.TransactionContextInterface, key string) bool {
+	objectType, _, err := ctx.GetStub().SplitCompositeKey(key)
+
+	return err == nil && objectType == "archivedReservation"
+}
+
+// reservation returns the archived reservation as far as the summary keeps it
+func (archived *ArchivedReservation) reservation() *Reservation {
+	reservation := &Reservation{
+		GroundID:          archived.GroundID,
+		ReservationNumber: archived.ReservationNumber,
+		UserID:            archived.UserID,
+		Begin:             archived.Begin,
+		End:               archived.End,
+		Players:           archived.Players,
+		Status:            archived.Status,
+		Quote: &PriceQuote{
+			Currency: archived.Currency,
+			Total:    archived.Total,
+		},
+	}
+
+	if !archived.BookedAt.IsZero() {
+		reservation.History = []*HistoryEntry{{
+			Action:    HistoryCreated,
+			ToUserID:  archived.UserID,
+			Timestamp: archived.BookedAt,
+		}}
+	}
+
+	return reservation
+}
+
+// archiveReservation replaces the reservation and its slots with its summary.
+// The reservation and its index by user are deleted, and the indexes by number and by day point at the summary.
+func archiveReservation(ctx contractapi.TransactionContextInterface, reservation *Reservation) error {
+	err := freeSlots(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
+	// the reservation itself, then its indexes by number, by user and by day
+	keys := reservationKeys(ctx, reservation)
+	for _, key := range []string{keys[0], keys[2]} {
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return fmt.Errorf("Failed to delete the world state. %s", err.Error())
+		}
+	}
+
+	archived := &ArchivedReservation{
+		GroundID:          reservation.GroundID,
+		ReservationNumber: reservation.ReservationNumber,
+		UserID:            reservation.UserID,
+		Begin:             reservation.Begin,
+		End:               reservation.End,
+		Players:           reservation.Players,
+		Status:            reservation.currentStatus(),
+		Key:               keys[0],
+		ArchivedTxID:      ctx.GetStub().GetTxID(),
+	}
+	if reservation.Quote != nil {
+		archived.Currency = reservation.Quote.Currency
+		archived.Total = reservation.Quote.Total
+	}
+	if len(reservation.History) > 0 {
+		archived.BookedAt = reservation.History[0].Timestamp
+	}
+
+	err = putArchivedReservation(ctx, archived)
+	if err != nil {
+		return err
+	}
+
+	archiveCompositeKey, _ := ctx.GetStub().CreateCompositeKey("archivedReservation", []string{reservation.GroundID, reservation.ReservationNumber})
+	for _, key := range []string{keys[1], keys[3]} {
+		err = ctx.GetStub().PutState(key, []byte(archiveCompositeKey))
+		if err != nil {
+			return err
+		}
+	}
+
+	return endorseByOwner(ctx, reservation.GroundID, keys[1], keys[3])
+}
+
+// putArchivedReservation writes the summary of an archived reservation to the world state
+func putArchivedReservation(ctx contractapi.TransactionContextInterface, archived *ArchivedReservation) error {
+	archived.SchemaVersion = schemaVersions["archivedReservation"]
+
+	archiveCompositeKey, _ := ctx.GetStub().CreateCompositeKey("archivedReservation", []string{archived.GroundID, archived.ReservationNumber})
+	archivedAsBytes, err := json.Marshal(archived)
+	if err != nil {
+		return fmt.Errorf("archivedReservation Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(archiveCompositeKey, archivedAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, archived.GroundID, archiveCompositeKey)
+}
@@ -0,0 +1,311 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Start types of a BlockBooking
+const (
+	// StartShotgun starts every group at the begin of the block, each on its own hole
+	StartShotgun = "shotgun"
+	// StartTeeTimes starts the groups one after another at their own tee times
+	StartTeeTimes = "teeTimes"
+)
+
+// BlockBooking is the struct that describes a tournament holding the whole ground for a time window
+type BlockBooking struct {
+	BlockID       string        `json:"blockID"`
+	GroundID      string        `json:"groundID"`
+	Name          string        `json:"name"`
+	OrganizerID   string        `json:"organizerID"`
+	Begin         time.Time     `json:"begin"`
+	End           time.Time     `json:"end"`
+	StartType     string        `json:"startType"`
+	Groups        []*BlockGroup `json:"groups"`
+	SchemaVersion uint          `json:"schemaVersion"`
+}
+
+// BlockGroup is the struct that describes a group of a BlockBooking.
+// Each group is also stored as a Reservation, whose number and game code link it to the score chaincode.
+type BlockGroup struct {
+	StartingHole      uint      `json:"startingHole"`
+	TeeTime           time.Time `json:"teeTime"`
+	Players           []string  `json:"players"`
+	ReservationNumber string    `json:"reservationNumber"`
+	GameCode          int       `json:"gameCode"`
+}
+
+// CreateBlockBooking is the invoke function that reserves the whole ground for a tournament.
+// Only the organizer or the owner of the ground may create it.
+// params - blockID, groundID, tournament name, organizer's ID, begin and end time, start type(shotgun or teeTimes)
+func (s *SmartContract) CreateBlockBooking(ctx contractapi.TransactionContextInterface, blockID string, groundID string, name string, organizerID string, begin string, end string, startType string) error {
+	fmt.Println("CreateBlockBooking called")
+
+	if startType != StartShotgun && startType != StartTeeTimes {
+		return newBookingError(ErrCodeInvalidRequest, "%s is not a start type", startType)
+	}
+
+	beginTime, err := parseTime(begin)
+	if err != nil {
+		return newBookingError(ErrCodeInvalidRequest, "begin %s", err.Error())
+	}
+	endTime, err := parseTime(end)
+	if err != nil {
+		return newBookingError(ErrCodeInvalidRequest, "end %s", err.Error())
+	}
+	err = checkPlayTime(beginTime, endTime)
+	if err != nil {
+		return err
+	}
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireUserOrOwner(ctx, ground, organizerID)
+	if err != nil {
+		return err
+	}
+
+	blockBooking, err := getBlockBooking(ctx, groundID, blockID)
+	if err != nil {
+		return err
+	}
+	if blockBooking != nil {
+		return newBookingError(ErrCodeInvalidRequest, "block booking %s already exists", blockID)
+	}
+
+	// the block takes the whole ground, so it must not overlap any reservation or other block
+	isPossible, err := validateReservation(ctx, groundID, beginTime, endTime, "")
+	if err != nil {
+		return fmt.Errorf("validate Error: %s", err.Error())
+	}
+	if !isPossible {
+		return newBookingError(ErrCodeSlotTaken, "that time is already reserved")
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = releaseExpiredHolds(ctx, groundID, beginTime, endTime, txTime)
+	if err != nil {
+		return err
+	}
+
+	blockBooking = &BlockBooking{
+		BlockID:     blockID,
+		GroundID:    groundID,
+		Name:        name,
+		OrganizerID: organizerID,
+		Begin:       beginTime,
+		End:         endTime,
+		StartType:   startType,
+		Groups:      []*BlockGroup{},
+	}
+
+	err = claimSlots(ctx, teeSlotKey, []string{groundID}, blockHolder(blockID), beginTime, endTime)
+	if err != nil {
+		return err
+	}
+
+	return putBlockBooking(ctx, blockBooking)
+}
+
+// AssignBlockGroup is the invoke function that adds a group to a block booking and reserves it.
+// For a shotgun start teeTime may be empty, as every group starts at the begin of the block.
+// Only the organizer of the block or the owner of the ground may assign a group.
+// params - groundID, blockID, starting hole, tee time, JSON array of the players' IDs
+func (s *SmartContract) AssignBlockGroup(ctx contractapi.TransactionContextInterface, groundID string, blockID string, startingHole uint, teeTime string, players string) error {
+	fmt.Println("AssignBlockGroup called")
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	blockBooking, err := s.QueryBlockBooking(ctx, groundID, blockID)
+	if err != nil {
+		return err
+	}
+
+	err = requireUserOrOwner(ctx, ground, blockBooking.OrganizerID)
+	if err != nil {
+		return err
+	}
+
+	var playerIDs []string
+	err = json.Unmarshal([]byte(players), &playerIDs)
+	if err != nil || len(playerIDs) == 0 {
+		return newBookingError(ErrCodeInvalidRequest, "players must be a JSON array of at least one userID")
+	}
+
+	participants, err := checkParticipants(playerIDs[0], playerIDs, uint(len(playerIDs)))
+	if err != nil {
+		return err
+	}
+
+	if startingHole < 1 || startingHole > ground.TotalHole {
+		return newBookingError(ErrCodeInvalidRequest, "starting hole %d is not on %s", startingHole, groundID)
+	}
+
+	teeTimeValue := blockBooking.Begin
+	if blockBooking.StartType == StartTeeTimes {
+		teeTimeValue, err = parseTime(teeTime)
+		if err != nil {
+			return newBookingError(ErrCodeInvalidRequest, "teeTime %s", err.Error())
+		}
+		if teeTimeValue.Before(blockBooking.Begin) || !teeTimeValue.Before(blockBooking.End) {
+			return newBookingError(ErrCodeInvalidRequest, "teeTime must be inside the block")
+		}
+
+		// the caddies and carts of the group are held from its tee time
+		err = checkSlotStart(teeTimeValue)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, group := range blockBooking.Groups {
+		if group.StartingHole == startingHole && group.TeeTime.Equal(teeTimeValue) {
+			return newBookingError(ErrCodeSlotTaken, "hole %d at %s is already assigned", startingHole, teeTimeValue.Format(time.RFC3339))
+		}
+	}
+
+	// the first player holds the reservation of the group
+	reservation := &Reservation{
+		GroundID:     groundID,
+		UserID:       playerIDs[0],
+		Begin:        teeTimeValue,
+		End:          blockBooking.End,
+		Players:      uint(len(playerIDs)),
+		BlockID:      blockID,
+		StartingHole: startingHole,
+		Participants: participants,
+	}
+
+	err = s.createReservation(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
+	blockBooking.Groups = append(blockBooking.Groups, &BlockGroup{
+		StartingHole:      startingHole,
+		TeeTime:           teeTimeValue,
+		Players:           playerIDs,
+		ReservationNumber: reservation.ReservationNumber,
+		GameCode:          reservation.GameCode,
+	})
+
+	err = putBlockBooking(ctx, blockBooking)
+	if err != nil {
+		return err
+	}
+
+	events := new(eventBatch)
+	err = events.add(EventReservationCreated, reservation)
+	if err != nil {
+		return err
+	}
+
+	return events.emit(ctx)
+}
+
+// QueryBlockBooking returns the block booking with its groups
+// params - groundID, blockID
+// returns the BlockBooking
+func (s *SmartContract) QueryBlockBooking(ctx contractapi.TransactionContextInterface, groundID string, blockID string) (*BlockBooking, error) {
+	blockBooking, err := getBlockBooking(ctx, groundID, blockID)
+	if err != nil {
+		return nil, err
+	}
+
+	if blockBooking == nil {
+		return nil, fmt.Errorf("%s does not exist", blockID)
+	}
+
+	return blockBooking, nil
+}
+
+// QueryGroundBlockBookings returns all block bookings of the ground
+// params - groundID
+// returns the array of BlockBooking
+func (s *SmartContract) QueryGroundBlockBookings(ctx contractapi.TransactionContextInterface, groundID string) ([]*BlockBooking, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("blockBooking", []string{groundID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var blockBookings []*BlockBooking
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		var blockBooking BlockBooking
+
+		err = json.Unmarshal(queryResponse.Value, &blockBooking)
+		if err != nil {
+			return nil, fmt.Errorf("blockBooking Unmarshal Error: %s", err.Error())
+		}
+
+		blockBookings = append(blockBookings, &blockBooking)
+	}
+
+	return blockBookings, nil
+}
+
+// getBlockBooking reads the block booking
+// returns nil without an error when it does not exist
+func getBlockBooking(ctx contractapi.TransactionContextInterface, groundID string, blockID string) (*BlockBooking, error) {
+	blockCompositeKey, _ := ctx.GetStub().CreateCompositeKey("blockBooking", []string{groundID, blockID})
+	blockAsBytes, err := ctx.GetStub().GetState(blockCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if blockAsBytes == nil {
+		return nil, nil
+	}
+
+	blockBooking := new(BlockBooking)
+	err = json.Unmarshal(blockAsBytes, blockBooking)
+	if err != nil {
+		return nil, fmt.Errorf("blockBooking Unmarshal Error: %s", err.Error())
+	}
+
+	return blockBooking, nil
+}
+
+// putBlockBooking writes the block booking to the world state
+func putBlockBooking(ctx contractapi.TransactionContextInterface, blockBooking *BlockBooking) error {
+	blockBooking.SchemaVersion = schemaVersions["blockBooking"]
+
+	blockCompositeKey, _ := ctx.GetStub().CreateCompositeKey("blockBooking", []string{blockBooking.GroundID, blockBooking.BlockID})
+
+	blockAsBytes, err := json.Marshal(blockBooking)
+	if err != nil {
+		return fmt.Errorf("blockBooking Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(blockCompositeKey, blockAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, blockBooking.GroundID, blockCompositeKey)
+}
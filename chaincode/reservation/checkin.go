@@ -0,0 +1,76 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// checkInWindow is how long before its tee time a group may check in
+const checkInWindow = time.Hour
+
+// CheckInReservation is the invoke function that records the arrival of the group of a booked reservation.
+// The group may check in from checkInWindow before its tee time until its play ends, and only once.
+// Only the owner of the ground, whose staff receives the group, may check it in.
+// params - reservation number
+func (s *SmartContract) CheckInReservation(ctx contractapi.TransactionContextInterface, reservationNumber string) error {
+	fmt.Println("CheckInReservation called")
+
+	reservation, err := getReservation(ctx, reservationNumber)
+	if err != nil {
+		return err
+	}
+	if reservation == nil {
+		return newBookingError(ErrCodeReservationNotFound, "%s does not exist", reservationNumber)
+	}
+
+	ground, err := s.QueryGround(ctx, reservation.GroundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireGroundOwner(ctx, ground)
+	if err != nil {
+		return err
+	}
+
+	if reservation.currentStatus() != StatusBooked {
+		return newBookingError(ErrCodeInvalidStatus, "%s is %s", reservationNumber, reservation.currentStatus())
+	}
+	if reservation.CheckedIn {
+		return newBookingError(ErrCodeInvalidStatus, "%s has already checked in", reservationNumber)
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if txTime.Before(reservation.Begin.Add(-checkInWindow)) || !txTime.Before(reservation.End) {
+		return newBookingError(ErrCodeCheckInWindow, "%s checks in from %s until %s", reservationNumber, reservation.Begin.Add(-checkInWindow).Format(time.RFC3339), reservation.End.Format(time.RFC3339))
+	}
+
+	// the time of the check-in is kept in the history
+	reservation.CheckedIn = true
+	err = appendHistory(ctx, reservation, HistoryCheckedIn, "", reservation.UserID)
+	if err != nil {
+		return err
+	}
+
+	err = putReservation(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
+	events := new(eventBatch)
+	err = events.add(EventReservationCheckedIn, reservation)
+	if err != nil {
+		return err
+	}
+
+	return events.emit(ctx)
+}
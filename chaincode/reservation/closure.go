@@ -0,0 +1,215 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// GroundClosure is the struct that describes a time the ground takes no play, e.g. for maintenance or weather.
+// It is also the payload of the GroundClosed event.
+type GroundClosure struct {
+	GroundID      string    `json:"groundID"`
+	ClosureID     string    `json:"closureID"`
+	Begin         time.Time `json:"begin"`
+	End           time.Time `json:"end"`
+	Reason        string    `json:"reason"`
+	SchemaVersion uint      `json:"schemaVersion"`
+}
+
+// CloseGround is the invoke function that closes the ground for a time, whose slots then cannot be booked.
+// The time must be free: the bookings in it are cancelled first. The ID of the closure is the ID of this transaction.
+// Only the owner of the ground may close it.
+// params - groundID, begin and end time of the closure, reason
+// returns the GroundClosure
+func (s *SmartContract) CloseGround(ctx contractapi.TransactionContextInterface, groundID string, begin string, end string, reason string) (*GroundClosure, error) {
+	fmt.Println("CloseGround called")
+
+	beginTime, err := parseTime(begin)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "begin %s", err.Error())
+	}
+	endTime, err := parseTime(end)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "end %s", err.Error())
+	}
+	err = checkPlayTime(beginTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return nil, newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireGroundOwner(ctx, ground)
+	if err != nil {
+		return nil, err
+	}
+
+	isPossible, err := validateReservation(ctx, groundID, beginTime, endTime, "")
+	if err != nil {
+		return nil, fmt.Errorf("validate Error: %s", err.Error())
+	}
+	if !isPossible {
+		return nil, newBookingError(ErrCodeSlotTaken, "that time is already reserved")
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = releaseExpiredHolds(ctx, groundID, beginTime, endTime, txTime)
+	if err != nil {
+		return nil, err
+	}
+
+	closure := &GroundClosure{
+		GroundID:  groundID,
+		ClosureID: ctx.GetStub().GetTxID(),
+		Begin:     beginTime,
+		End:       endTime,
+		Reason:    reason,
+	}
+
+	err = claimSlots(ctx, teeSlotKey, []string{groundID}, closureHolder(closure.ClosureID), beginTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putGroundClosure(ctx, closure)
+	if err != nil {
+		return nil, err
+	}
+
+	events := new(eventBatch)
+	err = events.add(EventGroundClosed, closure)
+	if err != nil {
+		return nil, err
+	}
+
+	err = events.emit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return closure, nil
+}
+
+// ReopenGround is the invoke function that ends a closure, so its time can be booked again.
+// Only the owner of the ground may reopen it.
+// params - groundID, closureID
+func (s *SmartContract) ReopenGround(ctx contractapi.TransactionContextInterface, groundID string, closureID string) error {
+	fmt.Println("ReopenGround called")
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireGroundOwner(ctx, ground)
+	if err != nil {
+		return err
+	}
+
+	closure, err := getGroundClosure(ctx, groundID, closureID)
+	if err != nil {
+		return err
+	}
+	if closure == nil {
+		return newBookingError(ErrCodeClosureNotFound, "%s has no closure %s", groundID, closureID)
+	}
+
+	err = releaseSlots(ctx, teeSlotKey, []string{groundID}, closureHolder(closureID), closure.Begin, closure.End)
+	if err != nil {
+		return err
+	}
+
+	closureCompositeKey, _ := ctx.GetStub().CreateCompositeKey("groundClosure", []string{groundID, closureID})
+
+	return ctx.GetStub().DelState(closureCompositeKey)
+}
+
+// QueryGroundClosures returns the closures of the ground
+// params - groundID
+// returns the array of GroundClosure
+func (s *SmartContract) QueryGroundClosures(ctx contractapi.TransactionContextInterface, groundID string) ([]*GroundClosure, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("groundClosure", []string{groundID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	closures := []*GroundClosure{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		closure := new(GroundClosure)
+		err = json.Unmarshal(queryResponse.Value, closure)
+		if err != nil {
+			return nil, fmt.Errorf("groundClosure Unmarshal Error: %s", err.Error())
+		}
+
+		closures = append(closures, closure)
+	}
+
+	return closures, nil
+}
+
+// closureHolder is the holder written to the slots of a closure
+func closureHolder(closureID string) string {
+	return "closure:" + closureID
+}
+
+// getGroundClosure reads the closure
+// returns nil without an error when it does not exist
+func getGroundClosure(ctx contractapi.TransactionContextInterface, groundID string, closureID string) (*GroundClosure, error) {
+	closureCompositeKey, _ := ctx.GetStub().CreateCompositeKey("groundClosure", []string{groundID, closureID})
+	closureAsBytes, err := ctx.GetStub().GetState(closureCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if closureAsBytes == nil {
+		return nil, nil
+	}
+
+	closure := new(GroundClosure)
+	err = json.Unmarshal(closureAsBytes, closure)
+	if err != nil {
+		return nil, fmt.Errorf("groundClosure Unmarshal Error: %s", err.Error())
+	}
+
+	return closure, nil
+}
+
+// putGroundClosure writes the closure to the world state
+func putGroundClosure(ctx contractapi.TransactionContextInterface, closure *GroundClosure) error {
+	closure.SchemaVersion = schemaVersions["groundClosure"]
+
+	closureCompositeKey, _ := ctx.GetStub().CreateCompositeKey("groundClosure", []string{closure.GroundID, closure.ClosureID})
+	closureAsBytes, err := json.Marshal(closure)
+	if err != nil {
+		return fmt.Errorf("groundClosure Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(closureCompositeKey, closureAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, closure.GroundID, closureCompositeKey)
+}
@@ -0,0 +1,402 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Discount types of a coupon
+const (
+	// DiscountPercent takes Value percent off the green fee
+	DiscountPercent = "percent"
+	// DiscountAmount takes Value off the green fee of the reservation, down to 0
+	DiscountAmount = "amount"
+)
+
+// Coupon is the struct that describes a promotion code redeemable at booking time.
+// It is redeemable by bookings made from ValidFrom up to ValidTo, for play at one of the EligibleGrounds
+// in one of the Bands (any time when empty).
+// The eligible grounds share one owner, OwnerMSP, which alone may change the coupon. A ground the owner
+// later transfers keeps its place in the list, but the coupon no longer applies there.
+// MaxRedemptions and MaxPerUser cap the bookings made with the code in total, over every ground, and per golfer;
+// 0 means no cap. Redeemed counts the bookings made with the code, and is kept when the coupon is replaced.
+type Coupon struct {
+	Code            string        `json:"code"`
+	EligibleGrounds []string      `json:"eligibleGrounds"`
+	OwnerMSP        string        `json:"ownerMSP"`
+	DiscountType    string        `json:"discountType"`
+	Value           uint          `json:"value"`
+	ValidFrom       time.Time     `json:"validFrom"`
+	ValidTo         time.Time     `json:"validTo"`
+	Bands           []*CouponBand `json:"bands,omitempty" metadata:"bands,optional"`
+	MaxRedemptions  uint          `json:"maxRedemptions"`
+	MaxPerUser      uint          `json:"maxPerUser"`
+	Redeemed        uint          `json:"redeemed"`
+	SchemaVersion   uint          `json:"schemaVersion"`
+}
+
+// CouponBand is the struct that describes the tee times a coupon applies to.
+// Weekdays are the English day names, and an empty list means every day.
+// The band covers play starting from StartHour up to, but not including, EndHour.
+type CouponBand struct {
+	Weekdays  []string `json:"weekdays,omitempty" metadata:"weekdays,optional"`
+	StartHour uint     `json:"startHour"`
+	EndHour   uint     `json:"endHour"`
+}
+
+// CouponRedemption is the struct that counts the bookings a golfer made with a code
+type CouponRedemption struct {
+	Code          string `json:"code"`
+	UserID        string `json:"userID"`
+	Count         uint   `json:"count"`
+	SchemaVersion uint   `json:"schemaVersion"`
+}
+
+// SetCoupon is the invoke function that creates or replaces a coupon.
+// The eligible grounds must all belong to the same owner, and only that owner may set the coupon.
+// A coupon that exists may only be replaced by its owner.
+// params - code, JSON of the Coupon
+func (s *SmartContract) SetCoupon(ctx contractapi.TransactionContextInterface, code string, coupon string) error {
+	fmt.Println("SetCoupon called")
+
+	if code == "" {
+		return newBookingError(ErrCodeInvalidRequest, "code is missing")
+	}
+
+	newCoupon := new(Coupon)
+	err := json.Unmarshal([]byte(coupon), newCoupon)
+	if err != nil {
+		return newBookingError(ErrCodeInvalidRequest, "coupon is not valid JSON. %s", err.Error())
+	}
+	newCoupon.Code = code
+
+	if len(newCoupon.EligibleGrounds) == 0 {
+		return newBookingError(ErrCodeInvalidRequest, "eligibleGrounds is missing")
+	}
+
+	for i, groundID := range newCoupon.EligibleGrounds {
+		ground, err := s.QueryGround(ctx, groundID)
+		if err != nil {
+			return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+		}
+
+		err = requireGroundOwner(ctx, ground)
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			newCoupon.OwnerMSP = ground.OwnerMSP
+		} else if ground.OwnerMSP != newCoupon.OwnerMSP {
+			return newBookingError(ErrCodeInvalidRequest, "%s and %s belong to different owners", newCoupon.EligibleGrounds[0], groundID)
+		}
+	}
+
+	switch newCoupon.DiscountType {
+	case DiscountPercent:
+		if newCoupon.Value == 0 || newCoupon.Value > 100 {
+			return newBookingError(ErrCodeInvalidRequest, "a percent discount must be from 1 to 100")
+		}
+	case DiscountAmount:
+		if newCoupon.Value == 0 {
+			return newBookingError(ErrCodeInvalidRequest, "an amount discount must be greater than 0")
+		}
+	default:
+		return newBookingError(ErrCodeInvalidRequest, "%s is not a discount type", newCoupon.DiscountType)
+	}
+
+	if !newCoupon.ValidTo.After(newCoupon.ValidFrom) {
+		return newBookingError(ErrCodeInvalidRequest, "validTo must be after validFrom")
+	}
+
+	for _, band := range newCoupon.Bands {
+		if band == nil {
+			return newBookingError(ErrCodeInvalidRequest, "band must not be null")
+		}
+		if band.StartHour >= band.EndHour || band.EndHour > 24 {
+			return newBookingError(ErrCodeInvalidRequest, "band hours %d-%d are not a valid band", band.StartHour, band.EndHour)
+		}
+		for _, weekday := range band.Weekdays {
+			if _, ok := parseWeekday(weekday); !ok {
+				return newBookingError(ErrCodeInvalidRequest, "%s is not a weekday", weekday)
+			}
+		}
+	}
+
+	// replacing a coupon does not reset its usage
+	existing, err := getCoupon(ctx, code)
+	if err != nil {
+		return err
+	}
+	newCoupon.Redeemed = 0
+	if existing != nil {
+		err = requireCouponOwner(ctx, existing)
+		if err != nil {
+			return err
+		}
+
+		newCoupon.Redeemed = existing.Redeemed
+	}
+
+	return putCoupon(ctx, newCoupon)
+}
+
+// QueryCoupon returns the coupon and how often it was redeemed
+// params - code
+// returns the Coupon
+func (s *SmartContract) QueryCoupon(ctx contractapi.TransactionContextInterface, code string) (*Coupon, error) {
+	coupon, err := getCoupon(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if coupon == nil {
+		return nil, newBookingError(ErrCodeCouponNotFound, "coupon %s does not exist", code)
+	}
+
+	return coupon, nil
+}
+
+// redeemCoupon takes the discount of the code off the quote and counts the redemption.
+// The coupon and the redemption of the golfer are written in the booking transaction, so two
+// bookings racing for the last use write the same keys and MVCC invalidates one of them.
+// A redemption is kept when the reservation is later cancelled.
+func redeemCoupon(ctx contractapi.TransactionContextInterface, code string, ground *Ground, userID string, beginTime time.Time, quote *PriceQuote, txTime time.Time) error {
+	coupon, err := getCoupon(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	if coupon == nil {
+		return newBookingError(ErrCodeCouponNotFound, "coupon %s does not exist", code)
+	}
+
+	if !coupon.eligible(ground) {
+		return newBookingError(ErrCodeCouponNotApplicable, "%s does not apply at %s", code, ground.GroundID)
+	}
+
+	if txTime.Before(coupon.ValidFrom) || !txTime.Before(coupon.ValidTo) {
+		return newBookingError(ErrCodeCouponNotApplicable, "%s is valid from %s to %s", code, coupon.ValidFrom.Format(time.RFC3339), coupon.ValidTo.Format(time.RFC3339))
+	}
+
+	if !coupon.appliesTo(beginTime) {
+		return newBookingError(ErrCodeCouponNotApplicable, "%s does not apply to that tee time", code)
+	}
+
+	if quote == nil {
+		return newBookingError(ErrCodeCouponNotApplicable, "that tee time has no green fee to discount")
+	}
+
+	if coupon.MaxRedemptions != 0 && coupon.Redeemed >= coupon.MaxRedemptions {
+		return newBookingError(ErrCodeCouponExhausted, "%s has been used %d times", code, coupon.Redeemed)
+	}
+
+	redemption, err := getCouponRedemption(ctx, code, userID)
+	if err != nil {
+		return err
+	}
+
+	if redemption == nil {
+		redemption = &CouponRedemption{
+			Code:   code,
+			UserID: userID,
+		}
+	}
+
+	if coupon.MaxPerUser != 0 && redemption.Count >= coupon.MaxPerUser {
+		return newBookingError(ErrCodeCouponExhausted, "%s has already used %s %d times", userID, code, redemption.Count)
+	}
+
+	discount := coupon.Value
+	if coupon.DiscountType == DiscountPercent {
+		discount = quote.Total * coupon.Value / 100
+	}
+	if discount > quote.Total {
+		discount = quote.Total
+	}
+
+	quote.CouponCode = code
+	quote.Discount = discount
+	quote.Total -= discount
+
+	coupon.Redeemed++
+	err = putCoupon(ctx, coupon)
+	if err != nil {
+		return err
+	}
+
+	redemption.Count++
+
+	return putCouponRedemption(ctx, coupon, redemption)
+}
+
+// eligible reports whether the coupon applies at the ground: the ground is listed and still belongs to the owner of the coupon
+func (coupon *Coupon) eligible(ground *Ground) bool {
+	if ground.OwnerMSP != coupon.OwnerMSP {
+		return false
+	}
+
+	for _, groundID := range coupon.EligibleGrounds {
+		if groundID == ground.GroundID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// appliesTo reports whether play starting at beginTime is eligible for the coupon
+func (coupon *Coupon) appliesTo(beginTime time.Time) bool {
+	if len(coupon.Bands) == 0 {
+		return true
+	}
+
+	for _, band := range coupon.Bands {
+		if band.appliesTo(beginTime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// appliesTo reports whether play starting at beginTime falls inside the band
+func (band *CouponBand) appliesTo(beginTime time.Time) bool {
+	hour := uint(beginTime.Hour())
+	if hour < band.StartHour || hour >= band.EndHour {
+		return false
+	}
+
+	if len(band.Weekdays) == 0 {
+		return true
+	}
+
+	for _, weekday := range band.Weekdays {
+		if day, _ := parseWeekday(weekday); day == beginTime.Weekday() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requireCouponOwner checks that the client belongs to the organization owning the coupon.
+// Anyone passes when the coupon has no owner.
+func requireCouponOwner(ctx contractapi.TransactionContextInterface, coupon *Coupon) error {
+	if coupon.OwnerMSP == "" {
+		return nil
+	}
+
+	mspID, err := clientMSP(ctx)
+	if err != nil {
+		return err
+	}
+
+	if mspID != coupon.OwnerMSP {
+		return newBookingError(ErrCodeNotOwner, "coupon %s is owned by %s, not %s", coupon.Code, coupon.OwnerMSP, mspID)
+	}
+
+	return nil
+}
+
+// endorseByCouponOwner requires the endorsement of the owner of the coupon to change the keys.
+// Nothing is set when the coupon has no owner.
+func endorseByCouponOwner(ctx contractapi.TransactionContextInterface, coupon *Coupon, keys ...string) error {
+	if coupon.OwnerMSP == "" {
+		return nil
+	}
+
+	policy, err := ownerEndorsementPolicy(coupon.OwnerMSP)
+	if err != nil {
+		return err
+	}
+
+	return setEndorsement(ctx, policy, keys...)
+}
+
+// getCoupon reads the coupon of the code
+// returns nil without an error when the code does not exist
+func getCoupon(ctx contractapi.TransactionContextInterface, code string) (*Coupon, error) {
+	couponCompositeKey, _ := ctx.GetStub().CreateCompositeKey("coupon", []string{code})
+	couponAsBytes, err := ctx.GetStub().GetState(couponCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if couponAsBytes == nil {
+		return nil, nil
+	}
+
+	coupon := new(Coupon)
+	err = json.Unmarshal(couponAsBytes, coupon)
+	if err != nil {
+		return nil, fmt.Errorf("coupon Unmarshal Error: %s", err.Error())
+	}
+
+	return coupon, nil
+}
+
+// putCoupon writes the coupon
+func putCoupon(ctx contractapi.TransactionContextInterface, coupon *Coupon) error {
+	coupon.SchemaVersion = schemaVersions["coupon"]
+
+	couponCompositeKey, _ := ctx.GetStub().CreateCompositeKey("coupon", []string{coupon.Code})
+	couponAsBytes, err := json.Marshal(coupon)
+	if err != nil {
+		return fmt.Errorf("coupon Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(couponCompositeKey, couponAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByCouponOwner(ctx, coupon, couponCompositeKey)
+}
+
+// getCouponRedemption reads how often the golfer used the code
+// returns nil without an error when the golfer has not used it
+func getCouponRedemption(ctx contractapi.TransactionContextInterface, code string, userID string) (*CouponRedemption, error) {
+	redemptionCompositeKey, _ := ctx.GetStub().CreateCompositeKey("couponRedemption", []string{code, userID})
+	redemptionAsBytes, err := ctx.GetStub().GetState(redemptionCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if redemptionAsBytes == nil {
+		return nil, nil
+	}
+
+	redemption := new(CouponRedemption)
+	err = json.Unmarshal(redemptionAsBytes, redemption)
+	if err != nil {
+		return nil, fmt.Errorf("couponRedemption Unmarshal Error: %s", err.Error())
+	}
+
+	return redemption, nil
+}
+
+// putCouponRedemption writes how often the golfer used the code, endorsed like the coupon
+func putCouponRedemption(ctx contractapi.TransactionContextInterface, coupon *Coupon, redemption *CouponRedemption) error {
+	redemption.SchemaVersion = schemaVersions["couponRedemption"]
+
+	redemptionCompositeKey, _ := ctx.GetStub().CreateCompositeKey("couponRedemption", []string{redemption.Code, redemption.UserID})
+	redemptionAsBytes, err := json.Marshal(redemption)
+	if err != nil {
+		return fmt.Errorf("couponRedemption Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(redemptionCompositeKey, redemptionAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByCouponOwner(ctx, coupon, redemptionCompositeKey)
+}
@@ -0,0 +1,231 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Course is the struct that describes a course of a ground and the par of each hole
+type Course struct {
+	GroundID      string `json:"groundID"`
+	CourseID      string `json:"courseID"`
+	CourseName    string `json:"courseName"`
+	Holes         uint   `json:"holes"`
+	Pars          []uint `json:"pars"`
+	SchemaVersion uint   `json:"schemaVersion"`
+}
+
+// RateTable is the struct that holds the green fees of a ground
+type RateTable struct {
+	GroundID      string  `json:"groundID"`
+	Currency      string  `json:"currency"`
+	Rates         []*Rate `json:"rates"`
+	SchemaVersion uint    `json:"schemaVersion"`
+}
+
+// Rate is the struct that describes the green fee per player of a time band.
+// Weekdays are the English day names, and an empty list means every day.
+// The band covers play starting from StartHour up to, but not including, EndHour.
+type Rate struct {
+	Weekdays  []string `json:"weekdays,omitempty" metadata:"weekdays,optional"`
+	StartHour uint     `json:"startHour"`
+	EndHour   uint     `json:"endHour"`
+	Price     uint     `json:"price"`
+}
+
+// PriceQuote is the struct that stamps the green fee of a reservation at booking time.
+// BasePrice is the price of the rate table; UnitPrice is what was charged after the dynamic
+// pricing of the ground adjusted it by AdjustPercent at the given occupancy percentage.
+// Total is UnitPrice for every player less the Discount of the redeemed coupon.
+type PriceQuote struct {
+	Currency      string `json:"currency"`
+	BasePrice     uint   `json:"basePrice"`
+	Occupancy     uint   `json:"occupancy"`
+	AdjustPercent int    `json:"adjustPercent"`
+	UnitPrice     uint   `json:"unitPrice"`
+	Players       uint   `json:"players"`
+	CouponCode    string `json:"couponCode,omitempty" metadata:"couponCode,optional"`
+	Discount      uint   `json:"discount"`
+	Total         uint   `json:"total"`
+}
+
+// QueryGroundCourses returns all courses of the ground
+// params - groundID
+// returns the array of Course
+func (s *SmartContract) QueryGroundCourses(ctx contractapi.TransactionContextInterface, groundID string) ([]*Course, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("course", []string{groundID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var courses []*Course
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		var course Course
+
+		err = json.Unmarshal(queryResponse.Value, &course)
+		if err != nil {
+			return nil, fmt.Errorf("course Unmarshal Error: %s", err.Error())
+		}
+
+		courses = append(courses, &course)
+	}
+
+	return courses, nil
+}
+
+// QueryRateTable returns the rate table of the ground
+// params - groundID
+// returns the RateTable
+func (s *SmartContract) QueryRateTable(ctx contractapi.TransactionContextInterface, groundID string) (*RateTable, error) {
+	rateTable, err := getRateTable(ctx, groundID)
+	if err != nil {
+		return nil, err
+	}
+
+	if rateTable == nil {
+		return nil, fmt.Errorf("rate table of %s does not exist", groundID)
+	}
+
+	return rateTable, nil
+}
+
+// getCourse reads the course of the ground
+// returns nil without an error when it does not exist
+func getCourse(ctx contractapi.TransactionContextInterface, groundID string, courseID string) (*Course, error) {
+	courseCompositeKey, _ := ctx.GetStub().CreateCompositeKey("course", []string{groundID, courseID})
+	courseAsBytes, err := ctx.GetStub().GetState(courseCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if courseAsBytes == nil {
+		return nil, nil
+	}
+
+	course := new(Course)
+	err = json.Unmarshal(courseAsBytes, course)
+	if err != nil {
+		return nil, fmt.Errorf("course Unmarshal Error: %s", err.Error())
+	}
+
+	return course, nil
+}
+
+// putCourse writes the course to the world state
+func putCourse(ctx contractapi.TransactionContextInterface, course *Course) error {
+	course.SchemaVersion = schemaVersions["course"]
+
+	courseCompositeKey, _ := ctx.GetStub().CreateCompositeKey("course", []string{course.GroundID, course.CourseID})
+
+	courseAsBytes, err := json.Marshal(course)
+	if err != nil {
+		return fmt.Errorf("course Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(courseCompositeKey, courseAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, course.GroundID, courseCompositeKey)
+}
+
+// getRateTable reads the rate table of the ground
+// returns nil without an error when it does not exist
+func getRateTable(ctx contractapi.TransactionContextInterface, groundID string) (*RateTable, error) {
+	rateCompositeKey, _ := ctx.GetStub().CreateCompositeKey("rateTable", []string{groundID})
+	rateAsBytes, err := ctx.GetStub().GetState(rateCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if rateAsBytes == nil {
+		return nil, nil
+	}
+
+	rateTable := new(RateTable)
+	err = json.Unmarshal(rateAsBytes, rateTable)
+	if err != nil {
+		return nil, fmt.Errorf("rateTable Unmarshal Error: %s", err.Error())
+	}
+
+	return rateTable, nil
+}
+
+// putRateTable writes the rate table to the world state
+func putRateTable(ctx contractapi.TransactionContextInterface, rateTable *RateTable) error {
+	rateTable.SchemaVersion = schemaVersions["rateTable"]
+
+	rateCompositeKey, _ := ctx.GetStub().CreateCompositeKey("rateTable", []string{rateTable.GroundID})
+
+	rateAsBytes, err := json.Marshal(rateTable)
+	if err != nil {
+		return fmt.Errorf("rateTable Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(rateCompositeKey, rateAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, rateTable.GroundID, rateCompositeKey)
+}
+
+// quote prices play starting at beginTime with the first rate whose band covers it
+// returns nil when the ground has no rate table or no rate applies
+func (rateTable *RateTable) quote(beginTime time.Time, players uint) *PriceQuote {
+	if rateTable == nil {
+		return nil
+	}
+
+	for _, rate := range rateTable.Rates {
+		if !rate.appliesTo(beginTime) {
+			continue
+		}
+
+		return &PriceQuote{
+			Currency:  rateTable.Currency,
+			BasePrice: rate.Price,
+			UnitPrice: rate.Price,
+			Players:   players,
+			Total:     rate.Price * players,
+		}
+	}
+
+	return nil
+}
+
+// appliesTo reports whether play starting at beginTime falls inside the band of the rate
+func (rate *Rate) appliesTo(beginTime time.Time) bool {
+	hour := uint(beginTime.Hour())
+	if hour < rate.StartHour || hour >= rate.EndHour {
+		return false
+	}
+
+	if len(rate.Weekdays) == 0 {
+		return true
+	}
+
+	for _, weekday := range rate.Weekdays {
+		if day, _ := parseWeekday(weekday); day == beginTime.Weekday() {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,66 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+)
+
+// Rejection codes returned by the booking transactions.
+// The client receives them as the prefix of the error message, e.g. "SLOT_TAKEN: ...".
+const (
+	ErrCodeInvalidRequest      = "INVALID_REQUEST"
+	ErrCodeGroundNotFound      = "GROUND_NOT_FOUND"
+	ErrCodeSlotTaken           = "SLOT_TAKEN"
+	ErrCodePolicyAdvanceWindow = "POLICY_ADVANCE_WINDOW"
+	ErrCodePolicyMaxActive     = "POLICY_MAX_ACTIVE_BOOKINGS"
+	ErrCodePolicyMinGroupSize  = "POLICY_MIN_GROUP_SIZE"
+	ErrCodePolicyInvalid       = "POLICY_INVALID"
+	ErrCodePolicyUnpaidShares  = "POLICY_UNPAID_SHARES"
+	ErrCodeReservationNotFound = "RESERVATION_NOT_FOUND"
+	ErrCodeNotOwner            = "NOT_OWNER"
+	ErrCodeAlreadyStarted      = "ALREADY_STARTED"
+	ErrCodeTransferDisabled    = "TRANSFER_DISABLED"
+	ErrCodeNoTransferOffer     = "NO_TRANSFER_OFFER"
+	ErrCodeInvalidStatus       = "INVALID_STATUS"
+	ErrCodeNotCompleted        = "RESERVATION_NOT_COMPLETED"
+	ErrCodeInvalidRating       = "INVALID_RATING"
+	ErrCodeAlreadyReviewed     = "ALREADY_REVIEWED"
+	ErrCodeReviewNotFound      = "REVIEW_NOT_FOUND"
+	ErrCodeResourceNotFound    = "RESOURCE_NOT_FOUND"
+	ErrCodeResourceUnavailable = "RESOURCE_UNAVAILABLE"
+	ErrCodeCouponNotFound      = "COUPON_NOT_FOUND"
+	ErrCodeCouponNotApplicable = "COUPON_NOT_APPLICABLE"
+	ErrCodeCouponExhausted     = "COUPON_EXHAUSTED"
+	ErrCodeHoldNotFound        = "HOLD_NOT_FOUND"
+	ErrCodeHoldExpired         = "HOLD_EXPIRED"
+	ErrCodeShareNotFound       = "SHARE_NOT_FOUND"
+	ErrCodeOpenCriteria        = "OPEN_CRITERIA_NOT_MET"
+	ErrCodePairingNotFound     = "PAIRING_REQUEST_NOT_FOUND"
+	ErrCodeHoldLimit           = "HOLD_LIMIT_REACHED"
+	ErrCodeWaitlistNotFound    = "WAITLIST_ENTRY_NOT_FOUND"
+	ErrCodeClosureNotFound     = "CLOSURE_NOT_FOUND"
+	ErrCodeCheckInWindow       = "CHECK_IN_WINDOW"
+)
+
+// BookingError is the error that describes why a booking transaction was rejected
+type BookingError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error returns the code followed by the message
+func (e *BookingError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// newBookingError creates a BookingError with the formatted message
+// params - rejection code, format and its arguments
+func newBookingError(code string, format string, args ...interface{}) error {
+	return &BookingError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,116 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// EventName is the name of the single chaincode event emitted by a transaction.
+// Fabric keeps only the last SetEvent of a transaction, so every business event
+// is delivered inside one EventEnvelope under this name.
+const EventName = "golfEvent"
+
+// EventSchemaVersion is the version of the EventEnvelope layout.
+// Increase it whenever the envelope or one of the payloads changes incompatibly.
+const EventSchemaVersion = 1
+
+// Business event types carried by an EventEnvelope
+const (
+	EventReservationCreated     = "ReservationCreated"
+	EventReservationCancelled   = "ReservationCancelled"
+	EventReservationRescheduled = "ReservationRescheduled"
+	EventReservationCheckedIn   = "ReservationCheckedIn"
+	EventReservationTransferred = "ReservationTransferred"
+	// EventWaitlistPromoted carries the JSON of the SlotHold offered to the golfer first on the waitlist
+	EventWaitlistPromoted = "WaitlistPromoted"
+	// EventGroundClosed carries the JSON of the GroundClosure
+	EventGroundClosed = "GroundClosed"
+	// EventGroundOwnershipTransferred carries the JSON of the Ground with its new owner
+	EventGroundOwnershipTransferred = "GroundOwnershipTransferred"
+	// EventOpenTeeTimeJoined carries the JSON of the Reservation a golfer joined
+	EventOpenTeeTimeJoined = "OpenTeeTimeJoined"
+	// EventShareClosed carries the JSON of a FeeShare that was settled or waived
+	EventShareClosed = "ShareClosed"
+)
+
+// Event is the struct that describes one business event.
+// Payload is the JSON of the Reservation for the reservation events, unless the type says otherwise.
+type Event struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// EventEnvelope is the struct emitted as the payload of the chaincode event
+type EventEnvelope struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	TxID          string    `json:"txID"`
+	Timestamp     time.Time `json:"timestamp"`
+	Events        []*Event  `json:"events"`
+}
+
+// eventBatch collects the business events of one transaction.
+// Call emit once, after every state change of the transaction has been written.
+type eventBatch struct {
+	events []*Event
+}
+
+// add appends an event of the given type to the batch
+// params - event type, payload to be marshalled
+func (b *eventBatch) add(eventType string, payload interface{}) error {
+	payloadAsBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("event payload Marshal Error: %s", err.Error())
+	}
+
+	b.events = append(b.events, &Event{
+		Type:    eventType,
+		Payload: payloadAsBytes,
+	})
+
+	return nil
+}
+
+// emit sets the chaincode event of the transaction with every collected event.
+// Nothing is emitted when the batch is empty.
+func (b *eventBatch) emit(ctx contractapi.TransactionContextInterface) error {
+	if len(b.events) == 0 {
+		return nil
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	envelope := EventEnvelope{
+		SchemaVersion: EventSchemaVersion,
+		TxID:          ctx.GetStub().GetTxID(),
+		Timestamp:     txTime,
+		Events:        b.events,
+	}
+
+	envelopeAsBytes, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("event envelope Marshal Error: %s", err.Error())
+	}
+
+	return ctx.GetStub().SetEvent(EventName, envelopeAsBytes)
+}
+
+// getTxTime returns the timestamp of the transaction proposal.
+// Every endorsing peer sees the same value, unlike time.Now().
+func getTxTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to get the transaction timestamp. %s", err.Error())
+	}
+
+	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC(), nil
+}
@@ -0,0 +1,385 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxHoldMinutes is the longest a slot can be held during checkout
+const maxHoldMinutes = 30
+
+// maxActiveHolds is the most holds a golfer may keep on a ground at once
+const maxActiveHolds = 2
+
+// holdHolderPrefix marks the slots held by a SlotHold
+const holdHolderPrefix = "hold:"
+
+// SlotHold is the struct that keeps a tee time for a golfer while they check out.
+// The hold takes the slot keys of its time until ExpiresAt, measured against the transaction timestamp.
+// An expired hold no longer blocks the time, and is deleted by the next booking that touches its slots.
+type SlotHold struct {
+	GroundID      string    `json:"groundID"`
+	HoldID        string    `json:"holdID"`
+	UserID        string    `json:"userID"`
+	Begin         time.Time `json:"begin"`
+	End           time.Time `json:"end"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+	SchemaVersion uint      `json:"schemaVersion"`
+}
+
+// HoldSlot is the invoke function that holds a tee time for some minutes, to be booked with ConfirmHold.
+// The ID of the hold is the ID of this transaction. The booking policy applies as to a booking of the
+// default group size, and a golfer keeps at most maxActiveHolds live holds on the ground.
+// params - groundID, userID, begin and end time of the play, minutes to hold(at most 30)
+// returns the SlotHold
+func (s *SmartContract) HoldSlot(ctx contractapi.TransactionContextInterface, groundID string, userID string, begin string, end string, minutes uint) (*SlotHold, error) {
+	fmt.Println("HoldSlot called")
+
+	beginTime, err := parseTime(begin)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "begin %s", err.Error())
+	}
+	endTime, err := parseTime(end)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "end %s", err.Error())
+	}
+	err = checkPlayTime(beginTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	if minutes == 0 || minutes > maxHoldMinutes {
+		return nil, newBookingError(ErrCodeInvalidRequest, "minutes must be from 1 to %d", maxHoldMinutes)
+	}
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return nil, newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = checkOpeningHours(ground, beginTime)
+	if err != nil {
+		return nil, err
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !txTime.Before(beginTime) {
+		return nil, newBookingError(ErrCodeAlreadyStarted, "that time has already started")
+	}
+
+	tier, err := userTier(ctx, groundID, userID, txTime)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkBookingPolicy(ctx, groundID, userID, tier, beginTime, defaultPlayers, txTime)
+	if err != nil {
+		return nil, err
+	}
+
+	activeHolds, err := countActiveHolds(ctx, groundID, userID, txTime)
+	if err != nil {
+		return nil, err
+	}
+	if activeHolds >= maxActiveHolds {
+		return nil, newBookingError(ErrCodeHoldLimit, "%s already keeps %d holds on %s", userID, activeHolds, groundID)
+	}
+
+	isPossible, err := validateReservation(ctx, groundID, beginTime, endTime, "")
+	if err != nil {
+		return nil, fmt.Errorf("validate Error: %s", err.Error())
+	}
+	if !isPossible {
+		return nil, newBookingError(ErrCodeSlotTaken, "that time is already reserved")
+	}
+
+	err = releaseExpiredHolds(ctx, groundID, beginTime, endTime, txTime)
+	if err != nil {
+		return nil, err
+	}
+
+	hold := &SlotHold{
+		GroundID:  groundID,
+		HoldID:    ctx.GetStub().GetTxID(),
+		UserID:    userID,
+		Begin:     beginTime,
+		End:       endTime,
+		ExpiresAt: txTime.Add(time.Duration(minutes) * time.Minute),
+	}
+
+	err = claimSlots(ctx, teeSlotKey, []string{groundID}, holdHolder(hold.HoldID), beginTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putSlotHold(ctx, hold)
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+// ConfirmHold is the invoke function that books the held tee time before the hold expires
+// params - groundID, holdID, userID, JSON of the ReservationOptions (may be empty)
+func (s *SmartContract) ConfirmHold(ctx contractapi.TransactionContextInterface, groundID string, holdID string, userID string, options string) error {
+	fmt.Println("ConfirmHold called")
+
+	reservationOptions := new(ReservationOptions)
+	if options != "" {
+		err := json.Unmarshal([]byte(options), reservationOptions)
+		if err != nil {
+			return newBookingError(ErrCodeInvalidRequest, "options are not valid JSON. %s", err.Error())
+		}
+	}
+
+	hold, err := getLiveHold(ctx, groundID, holdID, userID)
+	if err != nil {
+		return err
+	}
+
+	// the reservation takes over the slots of the hold
+	reservationOptions.holdID = holdID
+	_, err = s.reserve(ctx, groundID, userID, hold.Begin.Format(time.RFC3339), hold.End.Format(time.RFC3339), reservationOptions)
+	if err != nil {
+		return err
+	}
+
+	return deleteSlotHold(ctx, groundID, holdID)
+}
+
+// ReleaseHold is the invoke function that gives up a hold before it expires.
+// Only the golfer holding it or the owner of the ground may release it.
+// params - groundID, holdID, userID
+func (s *SmartContract) ReleaseHold(ctx contractapi.TransactionContextInterface, groundID string, holdID string, userID string) error {
+	fmt.Println("ReleaseHold called")
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireUserOrOwner(ctx, ground, userID)
+	if err != nil {
+		return err
+	}
+
+	hold, err := getLiveHold(ctx, groundID, holdID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = releaseSlots(ctx, teeSlotKey, []string{groundID}, holdHolder(holdID), hold.Begin, hold.End)
+	if err != nil {
+		return err
+	}
+
+	return deleteSlotHold(ctx, groundID, holdID)
+}
+
+// getLiveHold reads a hold of the golfer that has not expired
+func getLiveHold(ctx contractapi.TransactionContextInterface, groundID string, holdID string, userID string) (*SlotHold, error) {
+	hold, err := getSlotHold(ctx, groundID, holdID)
+	if err != nil {
+		return nil, err
+	}
+	if hold == nil {
+		return nil, newBookingError(ErrCodeHoldNotFound, "hold %s does not exist", holdID)
+	}
+
+	if hold.UserID != userID {
+		return nil, newBookingError(ErrCodeNotOwner, "%s does not hold %s", userID, holdID)
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !txTime.Before(hold.ExpiresAt) {
+		return nil, newBookingError(ErrCodeHoldExpired, "hold %s expired at %s", holdID, hold.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return hold, nil
+}
+
+// holdHolder is the holder written to the slots of a hold
+func holdHolder(holdID string) string {
+	return holdHolderPrefix + holdID
+}
+
+// holderHold reads the hold behind the holder of a slot.
+// The flag is false for the slots of reservations and block bookings, which keep them for good;
+// the hold is nil when it was already deleted.
+func holderHold(ctx contractapi.TransactionContextInterface, groundID string, holder string) (*SlotHold, bool, error) {
+	if !strings.HasPrefix(holder, holdHolderPrefix) {
+		return nil, false, nil
+	}
+
+	hold, err := getSlotHold(ctx, groundID, strings.TrimPrefix(holder, holdHolderPrefix))
+	if err != nil {
+		return nil, true, err
+	}
+
+	return hold, true, nil
+}
+
+// releaseExpiredHolds deletes the expired holds that still take a slot of the given time
+func releaseExpiredHolds(ctx contractapi.TransactionContextInterface, groundID string, beginTime, endTime time.Time, txTime time.Time) error {
+	seen := make(map[string]bool)
+
+	for _, start := range slotStarts(beginTime, endTime) {
+		key, err := slotKey(ctx, teeSlotKey, []string{groundID}, start)
+		if err != nil {
+			return err
+		}
+
+		holderAsBytes, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return fmt.Errorf("Failed to read from world state. %s", err.Error())
+		}
+
+		holder := string(holderAsBytes)
+		if seen[holder] {
+			continue
+		}
+
+		hold, isHold, err := holderHold(ctx, groundID, holder)
+		if err != nil {
+			return err
+		}
+		if !isHold {
+			continue
+		}
+		seen[holder] = true
+
+		// a slot left behind by a deleted hold is simply taken over
+		if hold == nil || txTime.Before(hold.ExpiresAt) {
+			continue
+		}
+
+		err = releaseSlots(ctx, teeSlotKey, []string{groundID}, holder, hold.Begin, hold.End)
+		if err != nil {
+			return err
+		}
+
+		err = deleteSlotHold(ctx, groundID, hold.HoldID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getSlotHold reads the hold
+// returns nil without an error when the hold does not exist
+func getSlotHold(ctx contractapi.TransactionContextInterface, groundID string, holdID string) (*SlotHold, error) {
+	holdCompositeKey, _ := ctx.GetStub().CreateCompositeKey("slotHold", []string{groundID, holdID})
+	holdAsBytes, err := ctx.GetStub().GetState(holdCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if holdAsBytes == nil {
+		return nil, nil
+	}
+
+	hold := new(SlotHold)
+	err = json.Unmarshal(holdAsBytes, hold)
+	if err != nil {
+		return nil, fmt.Errorf("slotHold Unmarshal Error: %s", err.Error())
+	}
+
+	return hold, nil
+}
+
+// countActiveHolds returns the number of holds of the user on the ground that have not expired
+func countActiveHolds(ctx contractapi.TransactionContextInterface, groundID string, userID string, txTime time.Time) (uint, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("userSlotHold", []string{groundID, userID})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	var count uint
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return 0, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return 0, err
+		}
+
+		hold, err := getSlotHold(ctx, groundID, attributes[2])
+		if err != nil {
+			return 0, err
+		}
+
+		if hold != nil && txTime.Before(hold.ExpiresAt) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// putSlotHold writes the hold and its index by user
+func putSlotHold(ctx contractapi.TransactionContextInterface, hold *SlotHold) error {
+	hold.SchemaVersion = schemaVersions["slotHold"]
+
+	holdCompositeKey, _ := ctx.GetStub().CreateCompositeKey("slotHold", []string{hold.GroundID, hold.HoldID})
+	holdAsBytes, err := json.Marshal(hold)
+	if err != nil {
+		return fmt.Errorf("slotHold Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(holdCompositeKey, holdAsBytes)
+	if err != nil {
+		return err
+	}
+
+	userIndexKey, _ := ctx.GetStub().CreateCompositeKey("userSlotHold", []string{hold.GroundID, hold.UserID, hold.HoldID})
+	err = ctx.GetStub().PutState(userIndexKey, []byte(holdCompositeKey))
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, hold.GroundID, holdCompositeKey, userIndexKey)
+}
+
+// deleteSlotHold deletes the hold and its index by user
+func deleteSlotHold(ctx contractapi.TransactionContextInterface, groundID string, holdID string) error {
+	hold, err := getSlotHold(ctx, groundID, holdID)
+	if err != nil {
+		return err
+	}
+	if hold == nil {
+		return nil
+	}
+
+	holdCompositeKey, _ := ctx.GetStub().CreateCompositeKey("slotHold", []string{groundID, holdID})
+	err = ctx.GetStub().DelState(holdCompositeKey)
+	if err != nil {
+		return err
+	}
+
+	userIndexKey, _ := ctx.GetStub().CreateCompositeKey("userSlotHold", []string{groundID, hold.UserID, holdID})
+
+	return ctx.GetStub().DelState(userIndexKey)
+}
@@ -0,0 +1,154 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// icalProductID identifies this chaincode as the producer of the calendars
+const icalProductID = "-//golfReservation//Tee Times//KO"
+
+// icalTimeFormat is the UTC date-time form of RFC 5545
+const icalTimeFormat = "20060102T150405Z"
+
+// icalLineLimit is the longest content line in octets before it is folded
+const icalLineLimit = 75
+
+// QueryUserCalendar is the query function that renders every reservation of the user as an iCalendar document
+// params - userID
+// returns the text/calendar document
+func (s *SmartContract) QueryUserCalendar(ctx contractapi.TransactionContextInterface, userID string) (string, error) {
+	reservations, err := s.UserConfirmReservation(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	return s.renderCalendar(ctx, reservations)
+}
+
+// QueryTeeSheetCalendar is the query function that renders the tee sheet of a ground for a day as an iCalendar document
+// params - groundID, any time of the day in RFC3339 (its offset sets the local day)
+// returns the text/calendar document
+func (s *SmartContract) QueryTeeSheetCalendar(ctx contractapi.TransactionContextInterface, groundID string, day string) (string, error) {
+	dayTime, err := parseTime(day)
+	if err != nil {
+		return "", err
+	}
+
+	dayBegin := time.Date(dayTime.Year(), dayTime.Month(), dayTime.Day(), 0, 0, 0, 0, dayTime.Location())
+	dayEnd := dayBegin.AddDate(0, 0, 1)
+
+	// the day index is kept in the offset each reservation was made with,
+	// so the days next to the requested one may hold some of its tee times
+	var teeSheet []*Reservation
+	for indexDay := dayBegin.AddDate(0, 0, -1); indexDay.Before(dayEnd.AddDate(0, 0, 1)); indexDay = indexDay.AddDate(0, 0, 1) {
+		reservations, err := queryDayReservations(ctx, groundID, indexDay.Format(dayFormat))
+		if err != nil {
+			return "", err
+		}
+
+		for _, reservation := range reservations {
+			if !reservation.Begin.Before(dayBegin) && reservation.Begin.Before(dayEnd) {
+				teeSheet = append(teeSheet, reservation)
+			}
+		}
+	}
+
+	return s.renderCalendar(ctx, teeSheet)
+}
+
+// renderCalendar writes the reservations as the events of a VCALENDAR.
+// The reservation number is the UID of an event, and every change of the reservation raises its SEQUENCE,
+// so a cancellation replaces the booked event in the golfer's calendar.
+func (s *SmartContract) renderCalendar(ctx contractapi.TransactionContextInterface, reservations []*Reservation) (string, error) {
+	sort.Slice(reservations, func(i, j int) bool {
+		return reservations[i].Begin.Before(reservations[j].Begin)
+	})
+
+	groundNames := make(map[string]string)
+
+	var calendar strings.Builder
+	writeICalLine(&calendar, "BEGIN:VCALENDAR")
+	writeICalLine(&calendar, "VERSION:2.0")
+	writeICalLine(&calendar, "PRODID:"+icalProductID)
+	writeICalLine(&calendar, "CALSCALE:GREGORIAN")
+	writeICalLine(&calendar, "METHOD:PUBLISH")
+
+	for _, reservation := range reservations {
+		groundName, ok := groundNames[reservation.GroundID]
+		if !ok {
+			groundName = reservation.GroundID
+			ground, err := s.QueryGround(ctx, reservation.GroundID)
+			if err == nil {
+				groundName = ground.GroundName
+			}
+			groundNames[reservation.GroundID] = groundName
+		}
+
+		// the time of the last change keeps the document the same on every peer
+		stamp := reservation.Begin
+		sequence := 0
+		if len(reservation.History) > 0 {
+			stamp = reservation.History[len(reservation.History)-1].Timestamp
+			sequence = len(reservation.History) - 1
+		}
+
+		status := "CONFIRMED"
+		if reservation.currentStatus() == StatusCancelled {
+			status = "CANCELLED"
+		}
+
+		description := fmt.Sprintf("Reservation %s\nGame code %04d\nPlayers %d", reservation.ReservationNumber, reservation.GameCode, reservation.Players)
+
+		writeICalLine(&calendar, "BEGIN:VEVENT")
+		writeICalLine(&calendar, "UID:"+escapeICalText(reservation.ReservationNumber))
+		writeICalLine(&calendar, "DTSTAMP:"+stamp.UTC().Format(icalTimeFormat))
+		writeICalLine(&calendar, "DTSTART:"+reservation.Begin.UTC().Format(icalTimeFormat))
+		writeICalLine(&calendar, "DTEND:"+reservation.End.UTC().Format(icalTimeFormat))
+		writeICalLine(&calendar, fmt.Sprintf("SEQUENCE:%d", sequence))
+		writeICalLine(&calendar, "STATUS:"+status)
+		writeICalLine(&calendar, "SUMMARY:"+escapeICalText("Tee time at "+groundName))
+		writeICalLine(&calendar, "LOCATION:"+escapeICalText(groundName))
+		writeICalLine(&calendar, "DESCRIPTION:"+escapeICalText(description))
+		writeICalLine(&calendar, "END:VEVENT")
+	}
+
+	writeICalLine(&calendar, "END:VCALENDAR")
+
+	return calendar.String(), nil
+}
+
+// writeICalLine writes a content line ended by CRLF, folding it at icalLineLimit octets
+// without splitting a UTF-8 character
+func writeICalLine(calendar *strings.Builder, line string) {
+	limit := icalLineLimit
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+
+		calendar.WriteString(line[:cut])
+		calendar.WriteString("\r\n ")
+		line = line[cut:]
+		// the leading space of a continuation line counts toward its length
+		limit = icalLineLimit - 1
+	}
+
+	calendar.WriteString(line)
+	calendar.WriteString("\r\n")
+}
+
+// escapeICalText escapes a TEXT value of RFC 5545
+func escapeICalText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\n", `\n`).Replace(text)
+}
@@ -0,0 +1,313 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxImportRows limits the grounds of one ImportGrounds call; larger imports are sent in chunks
+const maxImportRows = 100
+
+// Status of an imported row
+const (
+	ImportCreated   = "created"
+	ImportUnchanged = "unchanged"
+	ImportFailed    = "failed"
+)
+
+// GroundImport is the struct that describes one row of an import: a ground with its courses and rate table
+type GroundImport struct {
+	Ground    *Ground    `json:"ground"`
+	Courses   []*Course  `json:"courses"`
+	RateTable *RateTable `json:"rateTable"`
+}
+
+// ImportReport is the struct that reports the outcome of every row of an import
+type ImportReport struct {
+	Created   uint               `json:"created"`
+	Unchanged uint               `json:"unchanged"`
+	Failed    uint               `json:"failed"`
+	Rows      []*ImportRowResult `json:"rows"`
+}
+
+// ImportRowResult is the struct that reports the outcome of one row of an import
+type ImportRowResult struct {
+	Row      int      `json:"row"`
+	GroundID string   `json:"groundID"`
+	Status   string   `json:"status"`
+	Errors   []string `json:"errors,omitempty" metadata:"errors,optional"`
+}
+
+// ImportGrounds is the invoke function that adds grounds, courses and rate tables in bulk.
+// Every row is validated on its own and written only when it has no error.
+// A record that already exists is left as it is: an identical one is reported unchanged,
+// and a different one fails the row, so importing the same chunk again is harmless.
+// params - JSON array of GroundImport, at most maxImportRows long
+// returns the ImportReport
+func (s *SmartContract) ImportGrounds(ctx contractapi.TransactionContextInterface, grounds string) (*ImportReport, error) {
+	fmt.Println("ImportGrounds called")
+
+	var rows []*GroundImport
+	err := json.Unmarshal([]byte(grounds), &rows)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "grounds must be a JSON array. %s", err.Error())
+	}
+
+	if len(rows) > maxImportRows {
+		return nil, newBookingError(ErrCodeInvalidRequest, "at most %d grounds can be imported at once, split the import into chunks", maxImportRows)
+	}
+
+	report := &ImportReport{
+		Rows: []*ImportRowResult{},
+	}
+	// the world state does not show the writes of this transaction, so repeated grounds are caught here
+	seen := make(map[string]bool)
+
+	for i, row := range rows {
+		result := &ImportRowResult{
+			Row:    i,
+			Status: ImportFailed,
+		}
+		report.Rows = append(report.Rows, result)
+
+		if row == nil || row.Ground == nil {
+			result.Errors = []string{"ground is missing"}
+			report.Failed++
+			continue
+		}
+		result.GroundID = row.Ground.GroundID
+
+		result.Errors = validateGroundImport(row)
+		if len(result.Errors) == 0 && seen[row.Ground.GroundID] {
+			result.Errors = []string{"ground appears more than once in the import"}
+		}
+		seen[row.Ground.GroundID] = true
+
+		if len(result.Errors) > 0 {
+			report.Failed++
+			continue
+		}
+
+		created, errs, err := importGroundRow(ctx, row)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(errs) > 0 {
+			result.Errors = errs
+			report.Failed++
+		} else if created {
+			result.Status = ImportCreated
+			report.Created++
+		} else {
+			result.Status = ImportUnchanged
+			report.Unchanged++
+		}
+	}
+
+	return report, nil
+}
+
+// validateGroundImport checks the fields of a row and fills the groundID of its courses and rate table
+// returns the errors of the row
+func validateGroundImport(row *GroundImport) []string {
+	var errs []string
+
+	ground := row.Ground
+	if ground.GroundID == "" {
+		errs = append(errs, "groundID is missing")
+	}
+	if ground.GroundName == "" {
+		errs = append(errs, "groundName is missing")
+	}
+	if ground.AvailableTimeStart >= ground.AvailableTimeEnd || ground.AvailableTimeEnd > 24 {
+		errs = append(errs, fmt.Sprintf("available time %d-%d is not valid", ground.AvailableTimeStart, ground.AvailableTimeEnd))
+	}
+	if ground.TotalHole == 0 {
+		errs = append(errs, "totalHole is missing")
+	}
+
+	courseIDs := make(map[string]bool)
+	for i, course := range row.Courses {
+		if course == nil {
+			errs = append(errs, fmt.Sprintf("course %d is missing", i))
+			continue
+		}
+		if course.GroundID != "" && course.GroundID != ground.GroundID {
+			errs = append(errs, fmt.Sprintf("course %s belongs to %s", course.CourseID, course.GroundID))
+		}
+		course.GroundID = ground.GroundID
+
+		if course.CourseID == "" {
+			errs = append(errs, fmt.Sprintf("course %d has no courseID", i))
+		} else if courseIDs[course.CourseID] {
+			errs = append(errs, fmt.Sprintf("course %s appears more than once", course.CourseID))
+		}
+		courseIDs[course.CourseID] = true
+
+		if course.Holes == 0 || course.Holes > ground.TotalHole {
+			errs = append(errs, fmt.Sprintf("course %s has %d holes", course.CourseID, course.Holes))
+		}
+		if course.Pars == nil {
+			course.Pars = []uint{}
+		}
+		if len(course.Pars) != 0 && uint(len(course.Pars)) != course.Holes {
+			errs = append(errs, fmt.Sprintf("course %s has %d pars for %d holes", course.CourseID, len(course.Pars), course.Holes))
+		}
+		for hole, par := range course.Pars {
+			if par < 3 || par > 6 {
+				errs = append(errs, fmt.Sprintf("course %s hole %d has par %d", course.CourseID, hole+1, par))
+			}
+		}
+	}
+
+	if rateTable := row.RateTable; rateTable != nil {
+		if rateTable.GroundID != "" && rateTable.GroundID != ground.GroundID {
+			errs = append(errs, fmt.Sprintf("rate table belongs to %s", rateTable.GroundID))
+		}
+		rateTable.GroundID = ground.GroundID
+
+		if rateTable.Rates == nil {
+			rateTable.Rates = []*Rate{}
+		}
+		for i, rate := range rateTable.Rates {
+			if rate == nil {
+				errs = append(errs, fmt.Sprintf("rate %d is missing", i))
+				continue
+			}
+			if rate.StartHour >= rate.EndHour || rate.EndHour > 24 {
+				errs = append(errs, fmt.Sprintf("rate %d hours %d-%d are not a valid band", i, rate.StartHour, rate.EndHour))
+			}
+			if rate.Price == 0 {
+				errs = append(errs, fmt.Sprintf("rate %d has no price", i))
+			}
+			for _, weekday := range rate.Weekdays {
+				if _, ok := parseWeekday(weekday); !ok {
+					errs = append(errs, fmt.Sprintf("rate %d: %s is not a weekday", i, weekday))
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// importGroundRow writes the records of a valid row that do not exist yet.
+// Nothing is written when a record exists with different content.
+// returns whether a record was written, and the conflicts of the row
+func importGroundRow(ctx contractapi.TransactionContextInterface, row *GroundImport) (bool, []string, error) {
+	var errs []string
+	var writes []func() error
+	var writtenKeys []string
+
+	// the records are compared in the current schema
+	ground := row.Ground
+	ground.DocType = groundDocType
+	ground.SchemaVersion = schemaVersions["ground"]
+
+	groundCompositeKey, _ := ctx.GetStub().CreateCompositeKey("ground", []string{ground.GroundID})
+	groundAsBytes, err := ctx.GetStub().GetState(groundCompositeKey)
+	if err != nil {
+		return false, nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+	if groundAsBytes == nil {
+		// the organization of the client owns the grounds it imports
+		ground.OwnerMSP, err = clientMSP(ctx)
+		if err != nil {
+			return false, nil, err
+		}
+		writes = append(writes, func() error { return putGround(ctx, ground) })
+	} else {
+		existing, err := unmarshalGround(groundAsBytes)
+		if err != nil {
+			return false, nil, err
+		}
+		// only the owner may add records to an existing ground
+		err = requireGroundOwner(ctx, existing)
+		if err != nil {
+			return false, []string{err.Error()}, nil
+		}
+		ground.OwnerMSP = existing.OwnerMSP
+		if !sameJSON(existing, ground) {
+			errs = append(errs, fmt.Sprintf("ground %s already exists with different details", ground.GroundID))
+		}
+	}
+
+	for _, course := range row.Courses {
+		course := course
+		course.SchemaVersion = schemaVersions["course"]
+		existing, err := getCourse(ctx, course.GroundID, course.CourseID)
+		if err != nil {
+			return false, nil, err
+		}
+		if existing != nil {
+			existing.SchemaVersion = course.SchemaVersion
+		}
+		if existing == nil {
+			courseCompositeKey, _ := ctx.GetStub().CreateCompositeKey("course", []string{course.GroundID, course.CourseID})
+			writtenKeys = append(writtenKeys, courseCompositeKey)
+			writes = append(writes, func() error { return putCourse(ctx, course) })
+		} else if !sameJSON(existing, course) {
+			errs = append(errs, fmt.Sprintf("course %s already exists with different details", course.CourseID))
+		}
+	}
+
+	if rateTable := row.RateTable; rateTable != nil {
+		rateTable.SchemaVersion = schemaVersions["rateTable"]
+		existing, err := getRateTable(ctx, rateTable.GroundID)
+		if err != nil {
+			return false, nil, err
+		}
+		if existing != nil {
+			existing.SchemaVersion = rateTable.SchemaVersion
+		}
+		if existing == nil {
+			rateCompositeKey, _ := ctx.GetStub().CreateCompositeKey("rateTable", []string{rateTable.GroundID})
+			writtenKeys = append(writtenKeys, rateCompositeKey)
+			writes = append(writes, func() error { return putRateTable(ctx, rateTable) })
+		} else if !sameJSON(existing, rateTable) {
+			errs = append(errs, "rate table already exists with different details")
+		}
+	}
+
+	if len(errs) > 0 {
+		return false, errs, nil
+	}
+
+	for _, write := range writes {
+		err := write()
+		if err != nil {
+			return false, nil, err
+		}
+	}
+
+	// a ground written in this transaction is not in the world state yet, so its owner is applied here
+	if len(writtenKeys) > 0 && ground.OwnerMSP != "" {
+		policy, err := ownerEndorsementPolicy(ground.OwnerMSP)
+		if err != nil {
+			return false, nil, err
+		}
+
+		err = setEndorsement(ctx, policy, writtenKeys...)
+		if err != nil {
+			return false, nil, err
+		}
+	}
+
+	return len(writes) > 0, nil, nil
+}
+
+// sameJSON reports whether both values marshal to the same JSON
+func sameJSON(a interface{}, b interface{}) bool {
+	aAsBytes, errA := json.Marshal(a)
+	bAsBytes, errB := json.Marshal(b)
+
+	return errA == nil && errB == nil && bytes.Equal(aAsBytes, bAsBytes)
+}
@@ -0,0 +1,274 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Membership is the struct that describes a golfer's membership of the home ground.
+// The tier selects the advance window of the BookingPolicy while the membership is valid.
+type Membership struct {
+	UserID        string    `json:"userID"`
+	HomeGroundID  string    `json:"homeGroundID"`
+	Tier          string    `json:"tier"`
+	ValidFrom     time.Time `json:"validFrom"`
+	ValidTo       time.Time `json:"validTo"`
+	SchemaVersion uint      `json:"schemaVersion"`
+}
+
+// AvailableSlot is the struct that describes a free tee time and when the user may book it
+type AvailableSlot struct {
+	Begin       time.Time `json:"begin"`
+	End         time.Time `json:"end"`
+	ReleaseTime time.Time `json:"releaseTime"`
+	Bookable    bool      `json:"bookable"`
+}
+
+// SetMembership is the invoke function that creates or replaces the membership of a golfer.
+// Only the owner of the home ground may set its memberships.
+// params - userID, tier, valid from and valid to time, home groundID
+func (s *SmartContract) SetMembership(ctx contractapi.TransactionContextInterface, userID string, tier string, validFrom string, validTo string, homeGroundID string) error {
+	fmt.Println("SetMembership called")
+
+	if tier == "" || tier == TierVisitor {
+		return newBookingError(ErrCodeInvalidRequest, "%s is not a membership tier", tier)
+	}
+
+	ground, err := s.QueryGround(ctx, homeGroundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireGroundOwner(ctx, ground)
+	if err != nil {
+		return err
+	}
+
+	validFromTime, err := parseTime(validFrom)
+	if err != nil {
+		return newBookingError(ErrCodeInvalidRequest, "validFrom %s", err.Error())
+	}
+	validToTime, err := parseTime(validTo)
+	if err != nil {
+		return newBookingError(ErrCodeInvalidRequest, "validTo %s", err.Error())
+	}
+	if !validToTime.After(validFromTime) {
+		return newBookingError(ErrCodeInvalidRequest, "validTo must be after validFrom")
+	}
+
+	membership := &Membership{
+		UserID:       userID,
+		HomeGroundID: homeGroundID,
+		Tier:         tier,
+		ValidFrom:    validFromTime,
+		ValidTo:      validToTime,
+	}
+
+	return putMembership(ctx, membership)
+}
+
+// RevokeMembership is the invoke function that deletes the membership of a golfer.
+// Only the owner of the home ground may revoke its memberships.
+// params - home groundID, userID
+func (s *SmartContract) RevokeMembership(ctx contractapi.TransactionContextInterface, homeGroundID string, userID string) error {
+	fmt.Println("RevokeMembership called")
+
+	ground, err := s.QueryGround(ctx, homeGroundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireGroundOwner(ctx, ground)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.QueryMembership(ctx, homeGroundID, userID)
+	if err != nil {
+		return err
+	}
+
+	membershipCompositeKey, _ := ctx.GetStub().CreateCompositeKey("membership", []string{homeGroundID, userID})
+
+	return ctx.GetStub().DelState(membershipCompositeKey)
+}
+
+// QueryMembership returns the membership of the golfer at the home ground
+// params - home groundID, userID
+// returns the Membership
+func (s *SmartContract) QueryMembership(ctx contractapi.TransactionContextInterface, homeGroundID string, userID string) (*Membership, error) {
+	membership, err := getMembership(ctx, homeGroundID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if membership == nil {
+		return nil, fmt.Errorf("membership of %s at %s does not exist", userID, homeGroundID)
+	}
+
+	return membership, nil
+}
+
+// QueryGroundMemberships returns all memberships of the home ground
+// params - home groundID
+// returns the array of Membership
+func (s *SmartContract) QueryGroundMemberships(ctx contractapi.TransactionContextInterface, homeGroundID string) ([]*Membership, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("membership", []string{homeGroundID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var memberships []*Membership
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		var membership Membership
+
+		err = json.Unmarshal(queryResponse.Value, &membership)
+		if err != nil {
+			return nil, fmt.Errorf("membership Unmarshal Error: %s", err.Error())
+		}
+
+		memberships = append(memberships, &membership)
+	}
+
+	return memberships, nil
+}
+
+// QueryAvailableSlots is the query function that lists the free tee times of a day and when the user may book them
+// params - groundID, userID, any time of the day in RFC3339 (its offset sets the local day), slot length in minutes
+// returns the array of AvailableSlot
+func (s *SmartContract) QueryAvailableSlots(ctx contractapi.TransactionContextInterface, groundID string, userID string, day string, slotMinutes uint) ([]*AvailableSlot, error) {
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return nil, err
+	}
+
+	dayTime, err := parseTime(day)
+	if err != nil {
+		return nil, err
+	}
+
+	if slotMinutes == 0 {
+		return nil, fmt.Errorf("slotMinutes must be greater than 0")
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tier, err := userTier(ctx, groundID, userID, txTime)
+	if err != nil {
+		return nil, err
+	}
+
+	bookingPolicy, err := getBookingPolicy(ctx, groundID)
+	if err != nil {
+		return nil, err
+	}
+
+	opening := time.Date(dayTime.Year(), dayTime.Month(), dayTime.Day(), int(ground.AvailableTimeStart), 0, 0, 0, dayTime.Location())
+	closing := time.Date(dayTime.Year(), dayTime.Month(), dayTime.Day(), int(ground.AvailableTimeEnd), 0, 0, 0, dayTime.Location())
+	slotLength := time.Duration(slotMinutes) * time.Minute
+
+	var slots []*AvailableSlot
+
+	for begin := opening; !begin.Add(slotLength).After(closing); begin = begin.Add(slotLength) {
+		end := begin.Add(slotLength)
+
+		// reservations and block bookings both hold the slot keys
+		isFree, err := validateReservation(ctx, groundID, begin, end, "")
+		if err != nil {
+			return nil, err
+		}
+		if !isFree {
+			continue
+		}
+
+		slot := &AvailableSlot{
+			Begin:    begin,
+			End:      end,
+			Bookable: true,
+		}
+		if bookingPolicy != nil {
+			releaseTime, limited := bookingPolicy.releaseTime(tier, begin)
+			if limited {
+				slot.ReleaseTime = releaseTime
+				slot.Bookable = !txTime.Before(releaseTime)
+			}
+		}
+
+		slots = append(slots, slot)
+	}
+
+	return slots, nil
+}
+
+// getMembership reads the membership of the golfer at the home ground
+// returns nil without an error when the golfer is not a member
+func getMembership(ctx contractapi.TransactionContextInterface, homeGroundID string, userID string) (*Membership, error) {
+	membershipCompositeKey, _ := ctx.GetStub().CreateCompositeKey("membership", []string{homeGroundID, userID})
+	membershipAsBytes, err := ctx.GetStub().GetState(membershipCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if membershipAsBytes == nil {
+		return nil, nil
+	}
+
+	membership := new(Membership)
+	err = json.Unmarshal(membershipAsBytes, membership)
+	if err != nil {
+		return nil, fmt.Errorf("membership Unmarshal Error: %s", err.Error())
+	}
+
+	return membership, nil
+}
+
+// putMembership writes the membership to the world state
+func putMembership(ctx contractapi.TransactionContextInterface, membership *Membership) error {
+	membership.SchemaVersion = schemaVersions["membership"]
+
+	membershipCompositeKey, _ := ctx.GetStub().CreateCompositeKey("membership", []string{membership.HomeGroundID, membership.UserID})
+	membershipAsBytes, err := json.Marshal(membership)
+	if err != nil {
+		return fmt.Errorf("membership Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(membershipCompositeKey, membershipAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, membership.HomeGroundID, membershipCompositeKey)
+}
+
+// userTier returns the booking tier of the user at the ground at the given time.
+// A golfer without a valid membership of the ground books as TierVisitor.
+func userTier(ctx contractapi.TransactionContextInterface, groundID string, userID string, at time.Time) (string, error) {
+	membership, err := getMembership(ctx, groundID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if membership == nil || at.Before(membership.ValidFrom) || !at.Before(membership.ValidTo) {
+		return TierVisitor, nil
+	}
+
+	return membership.Tier, nil
+}
@@ -0,0 +1,304 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxGroupSize is the size of a full group, which closes an open tee time
+const maxGroupSize = 4
+
+// Genders accepted by OpenTeeTime
+const (
+	GenderMale   = "male"
+	GenderFemale = "female"
+)
+
+// OpenTeeTime is the struct that offers the free spots of a reservation to single golfers.
+// Handicap and Gender are optional criteria a golfer must meet to join.
+type OpenTeeTime struct {
+	Spots    uint           `json:"spots"`
+	Handicap *HandicapRange `json:"handicap,omitempty" metadata:"handicap,optional"`
+	Gender   string         `json:"gender,omitempty" metadata:"gender,optional"`
+}
+
+// HandicapRange is the struct that bounds the handicap of a golfer, both ends included
+type HandicapRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// OpenTeeTimeFilter is the struct that describes a search of open tee times.
+// From and To are days (2006-01-02) and cover at most 31 days.
+// A golfer who gives a handicap or gender sees only the tee times they may join.
+type OpenTeeTimeFilter struct {
+	GroundID string   `json:"groundID"`
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	Handicap *float64 `json:"handicap"`
+	Gender   string   `json:"gender"`
+}
+
+// OpenReservation is the invoke function that offers the free spots of a reservation to single golfers.
+// Spots of 0 withdraws the offer.
+// params - reservation number, userID of the holder, JSON of the OpenTeeTime
+func (s *SmartContract) OpenReservation(ctx contractapi.TransactionContextInterface, reservationNumber string, userID string, open string) error {
+	fmt.Println("OpenReservation called")
+
+	openTeeTime := new(OpenTeeTime)
+	err := json.Unmarshal([]byte(open), openTeeTime)
+	if err != nil {
+		return newBookingError(ErrCodeInvalidRequest, "open tee time is not valid JSON. %s", err.Error())
+	}
+
+	if openTeeTime.Handicap != nil && openTeeTime.Handicap.Min > openTeeTime.Handicap.Max {
+		return newBookingError(ErrCodeInvalidRequest, "handicap min must not be above max")
+	}
+	if openTeeTime.Gender != "" && openTeeTime.Gender != GenderMale && openTeeTime.Gender != GenderFemale {
+		return newBookingError(ErrCodeInvalidRequest, "%s is not a gender", openTeeTime.Gender)
+	}
+
+	reservation, err := getJoinableReservation(ctx, reservationNumber)
+	if err != nil {
+		return err
+	}
+
+	if reservation.UserID != userID {
+		return newBookingError(ErrCodeNotOwner, "%s does not hold %s", userID, reservationNumber)
+	}
+	if reservation.Players+openTeeTime.Spots > maxGroupSize {
+		return newBookingError(ErrCodeInvalidRequest, "a group of %d has %d free spots at most", reservation.Players, maxGroupSize-reservation.Players)
+	}
+
+	reservation.Open = openTeeTime
+	if openTeeTime.Spots == 0 {
+		reservation.Open = nil
+	}
+
+	return putReservation(ctx, reservation)
+}
+
+// JoinOpenTeeTime is the invoke function that adds a single golfer to an open tee time.
+// The golfer who fills the last spot closes the tee time and a new game code is drawn for the group.
+// The handicap of the golfer is read from the score chaincode, and the booking policy of the ground
+// applies to the golfer as to a booking of the grown group.
+// The quote of a priced tee time grows by one player at the unit price stamped at booking, and the golfer
+// owes the holder that price as a FeeShare; a coupon discount stays with the holder.
+// params - reservation number, userID, gender of the golfer
+func (s *SmartContract) JoinOpenTeeTime(ctx contractapi.TransactionContextInterface, reservationNumber string, userID string, gender string) error {
+	fmt.Println("JoinOpenTeeTime called")
+
+	reservation, err := getJoinableReservation(ctx, reservationNumber)
+	if err != nil {
+		return err
+	}
+
+	if reservation.Open == nil {
+		return newBookingError(ErrCodeInvalidStatus, "%s is not open", reservationNumber)
+	}
+
+	var handicap float64
+	if reservation.Open.Handicap != nil {
+		var found bool
+		handicap, found, err = queryHandicapIndex(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return newBookingError(ErrCodeOpenCriteria, "%s has no handicap index for the range of %s", userID, reservationNumber)
+		}
+	}
+	if !reservation.Open.admits(handicap, gender) {
+		return newBookingError(ErrCodeOpenCriteria, "%s does not meet the criteria of %s", userID, reservationNumber)
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	tier, err := userTier(ctx, reservation.GroundID, userID, txTime)
+	if err != nil {
+		return err
+	}
+
+	err = checkBookingPolicy(ctx, reservation.GroundID, userID, tier, reservation.Begin, reservation.Players+1, txTime)
+	if err != nil {
+		return err
+	}
+
+	if len(reservation.Participants) == 0 {
+		reservation.Participants = []string{reservation.UserID}
+	}
+	for _, participant := range reservation.Participants {
+		if participant == userID {
+			return newBookingError(ErrCodeInvalidRequest, "%s already plays in %s", userID, reservationNumber)
+		}
+	}
+
+	reservation.Participants = append(reservation.Participants, userID)
+	reservation.Players++
+	reservation.Open.Spots--
+
+	if reservation.Quote != nil {
+		reservation.Quote.Players = reservation.Players
+		reservation.Quote.Total = reservation.Quote.UnitPrice*reservation.Quote.Players - reservation.Quote.Discount
+	}
+
+	err = appendHistory(ctx, reservation, HistoryJoined, "", userID)
+	if err != nil {
+		return err
+	}
+
+	if reservation.Open.Spots == 0 || reservation.Players >= maxGroupSize {
+		reservation.Open = nil
+		reservation.GameCode = txGameCode(ctx.GetStub().GetTxID())
+	}
+
+	err = putReservation(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
+	if reservation.Quote != nil {
+		err = putShare(ctx, &FeeShare{
+			GroundID:          reservation.GroundID,
+			ReservationNumber: reservation.ReservationNumber,
+			UserID:            userID,
+			PayeeID:           reservation.UserID,
+			Begin:             reservation.Begin,
+			Currency:          reservation.Quote.Currency,
+			Amount:            reservation.Quote.UnitPrice,
+			Status:            ShareUnpaid,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// the golfer no longer waits for a game that day
+	err = deletePairingRequest(ctx, reservation.GroundID, reservation.Begin.Format(dayFormat), userID)
+	if err != nil {
+		return err
+	}
+
+	events := new(eventBatch)
+	err = events.add(EventOpenTeeTimeJoined, reservation)
+	if err != nil {
+		return err
+	}
+
+	return events.emit(ctx)
+}
+
+// SearchOpenTeeTimes is the query function that returns the open tee times of a ground that have not started
+// params - JSON of the OpenTeeTimeFilter
+// returns the array of reservations
+func (s *SmartContract) SearchOpenTeeTimes(ctx contractapi.TransactionContextInterface, filter string) ([]*Reservation, error) {
+	openFilter := new(OpenTeeTimeFilter)
+	err := json.Unmarshal([]byte(filter), openFilter)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "filter is not valid JSON. %s", err.Error())
+	}
+
+	_, err = s.QueryGround(ctx, openFilter.GroundID)
+	if err != nil {
+		return nil, newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	firstDay, err := time.Parse(dayFormat, openFilter.From)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "from must be a day like 2006-01-02")
+	}
+	lastDay, err := time.Parse(dayFormat, openFilter.To)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "to must be a day like 2006-01-02")
+	}
+	if lastDay.Before(firstDay) || lastDay.After(firstDay.AddDate(0, 0, maxAnalyticsDays-1)) {
+		return nil, newBookingError(ErrCodeInvalidRequest, "from and to must cover 1 to %d days", maxAnalyticsDays)
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reservations := []*Reservation{}
+
+	for day := firstDay; !day.After(lastDay); day = day.AddDate(0, 0, 1) {
+		dayReservations, err := queryDayReservations(ctx, openFilter.GroundID, day.Format(dayFormat))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, reservation := range dayReservations {
+			if reservation.Open == nil || reservation.currentStatus() != StatusBooked || !txTime.Before(reservation.Begin) {
+				continue
+			}
+
+			if !openFilter.matches(reservation.Open) {
+				continue
+			}
+
+			reservations = append(reservations, reservation)
+		}
+	}
+
+	return reservations, nil
+}
+
+// admits reports whether a golfer meets the criteria of the open tee time
+func (openTeeTime *OpenTeeTime) admits(handicap float64, gender string) bool {
+	if openTeeTime.Handicap != nil && (handicap < openTeeTime.Handicap.Min || handicap > openTeeTime.Handicap.Max) {
+		return false
+	}
+
+	return openTeeTime.Gender == "" || openTeeTime.Gender == gender
+}
+
+// matches reports whether the golfer described by the filter may join the open tee time.
+// Criteria the filter gives no value for are not checked.
+func (openFilter *OpenTeeTimeFilter) matches(openTeeTime *OpenTeeTime) bool {
+	if openFilter.Handicap != nil && openTeeTime.Handicap != nil {
+		if *openFilter.Handicap < openTeeTime.Handicap.Min || *openFilter.Handicap > openTeeTime.Handicap.Max {
+			return false
+		}
+	}
+
+	return openFilter.Gender == "" || openTeeTime.Gender == "" || openTeeTime.Gender == openFilter.Gender
+}
+
+// getJoinableReservation reads a booked reservation that has not started and is not part of a block
+func getJoinableReservation(ctx contractapi.TransactionContextInterface, reservationNumber string) (*Reservation, error) {
+	reservation, err := getReservation(ctx, reservationNumber)
+	if err != nil {
+		return nil, err
+	}
+	if reservation == nil {
+		return nil, newBookingError(ErrCodeReservationNotFound, "%s does not exist", reservationNumber)
+	}
+
+	if reservation.BlockID != "" {
+		return nil, newBookingError(ErrCodeInvalidStatus, "%s belongs to block booking %s", reservationNumber, reservation.BlockID)
+	}
+	if reservation.currentStatus() != StatusBooked {
+		return nil, newBookingError(ErrCodeInvalidStatus, "%s is %s", reservationNumber, reservation.currentStatus())
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !txTime.Before(reservation.Begin) {
+		return nil, newBookingError(ErrCodeAlreadyStarted, "%s has already started", reservationNumber)
+	}
+
+	return reservation, nil
+}
@@ -0,0 +1,263 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// userIDAttribute is the certificate attribute the CA enrolls a golfer's userID under
+const userIDAttribute = "userID"
+
+// groundRecordTypes are the object types of the records kept under a ground, next to its reservations.
+// Their keys start with the groundID and are endorsed by the owner of the ground.
+var groundRecordTypes = []string{
+	teeSlotKey,
+	resourceSlotKey,
+	"bookingPolicy",
+	"membership",
+	"blockBooking",
+	"review",
+	"reviewSummary",
+	"course",
+	"rateTable",
+	"resource",
+	"pricingPolicy",
+	"slotHold",
+	"userSlotHold",
+	"archivedReservation",
+	"pairingRequest",
+	"waitlist",
+	"groundClosure",
+}
+
+// TransferGroundOwnership is the invoke function that hands a ground over to another organization.
+// The ground, its reservations and its other records then need the endorsement of the new owner to change.
+// Only the current owner may transfer a ground; a ground without an owner is governed by the chaincode
+// endorsement policy until its first transfer.
+// params - groundID, MSP ID of the new owner
+func (s *SmartContract) TransferGroundOwnership(ctx contractapi.TransactionContextInterface, groundID string, newOwnerMSP string) error {
+	fmt.Println("TransferGroundOwnership called")
+
+	if newOwnerMSP == "" {
+		return newBookingError(ErrCodeInvalidRequest, "the new owner is missing")
+	}
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireGroundOwner(ctx, ground)
+	if err != nil {
+		return err
+	}
+
+	ground.OwnerMSP = newOwnerMSP
+	err = putGround(ctx, ground)
+	if err != nil {
+		return err
+	}
+
+	// the world state still shows the old owner in this transaction, so the policy is passed on directly
+	policy, err := ownerEndorsementPolicy(newOwnerMSP)
+	if err != nil {
+		return err
+	}
+
+	reservations, err := queryGroundReservations(ctx, groundID)
+	if err != nil {
+		return err
+	}
+
+	for _, reservation := range reservations {
+		err = setEndorsement(ctx, policy, reservationKeys(ctx, reservation)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	// a share is also indexed under its participant, outside the keys of the ground
+	shares, err := queryGroundShares(ctx, groundID)
+	if err != nil {
+		return err
+	}
+
+	for _, share := range shares {
+		err = setEndorsement(ctx, policy, shareKeys(ctx, share)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, recordType := range groundRecordTypes {
+		resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(recordType, []string{groundID})
+		if err != nil {
+			return err
+		}
+
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+
+			if err != nil {
+				resultsIterator.Close()
+				return err
+			}
+
+			err = setEndorsement(ctx, policy, queryResponse.Key)
+			if err != nil {
+				resultsIterator.Close()
+				return err
+			}
+		}
+
+		resultsIterator.Close()
+	}
+
+	// the tokens of the old owner no longer confirm bookings; the new owner sets a key of its own
+	publicKeyCompositeKey, _ := ctx.GetStub().CreateCompositeKey("confirmationPublicKey", []string{groundID})
+	err = ctx.GetStub().DelState(publicKeyCompositeKey)
+	if err != nil {
+		return fmt.Errorf("Failed to delete from world state. %s", err.Error())
+	}
+
+	events := new(eventBatch)
+	err = events.add(EventGroundOwnershipTransferred, ground)
+	if err != nil {
+		return err
+	}
+
+	return events.emit(ctx)
+}
+
+// requireGroundOwner checks that the client belongs to the organization owning the ground
+func requireGroundOwner(ctx contractapi.TransactionContextInterface, ground *Ground) error {
+	if ground.OwnerMSP == "" {
+		return nil
+	}
+
+	mspID, err := clientMSP(ctx)
+	if err != nil {
+		return err
+	}
+
+	if mspID != ground.OwnerMSP {
+		return newBookingError(ErrCodeNotOwner, "%s is owned by %s, not %s", ground.GroundID, ground.OwnerMSP, mspID)
+	}
+
+	return nil
+}
+
+// requireUserOrOwner checks that the client is the golfer, by the userID attribute of its certificate,
+// or belongs to the organization owning the ground and so acts for the golfer
+func requireUserOrOwner(ctx contractapi.TransactionContextInterface, ground *Ground, userID string) error {
+	clientUserID, found, err := ctx.GetClientIdentity().GetAttributeValue(userIDAttribute)
+	if err != nil {
+		return fmt.Errorf("Failed to get the client identity. %s", err.Error())
+	}
+
+	if found && clientUserID == userID {
+		return nil
+	}
+
+	return requireGroundOwner(ctx, ground)
+}
+
+// requireUser checks that the client is the golfer, by the userID attribute of its certificate,
+// for what nobody may do for the golfer
+func requireUser(ctx contractapi.TransactionContextInterface, userID string) error {
+	clientUserID, found, err := ctx.GetClientIdentity().GetAttributeValue(userIDAttribute)
+	if err != nil {
+		return fmt.Errorf("Failed to get the client identity. %s", err.Error())
+	}
+
+	if !found || clientUserID != userID {
+		return newBookingError(ErrCodeNotOwner, "the client is not %s", userID)
+	}
+
+	return nil
+}
+
+// clientMSP returns the MSP ID of the organization of the client
+func clientMSP(ctx contractapi.TransactionContextInterface) (string, error) {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get the client identity. %s", err.Error())
+	}
+
+	return mspID, nil
+}
+
+// ownerEndorsementPolicy creates the key-level endorsement policy that requires a peer of the owner
+func ownerEndorsementPolicy(ownerMSP string) ([]byte, error) {
+	endorsementPolicy, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = endorsementPolicy.AddOrgs(statebased.RoleTypePeer, ownerMSP)
+	if err != nil {
+		return nil, err
+	}
+
+	return endorsementPolicy.Policy()
+}
+
+// setEndorsement sets the key-level endorsement policy of the keys
+func setEndorsement(ctx contractapi.TransactionContextInterface, policy []byte, keys ...string) error {
+	for _, key := range keys {
+		err := ctx.GetStub().SetStateValidationParameter(key, policy)
+		if err != nil {
+			return fmt.Errorf("Failed to set the endorsement policy of %s. %s", key, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// endorseByOwner requires the endorsement of the owner of the ground to change the keys.
+// Nothing is set when the ground has no owner.
+func endorseByOwner(ctx contractapi.TransactionContextInterface, groundID string, keys ...string) error {
+	groundCompositeKey, _ := ctx.GetStub().CreateCompositeKey("ground", []string{groundID})
+	groundAsBytes, err := ctx.GetStub().GetState(groundCompositeKey)
+	if err != nil {
+		return fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if groundAsBytes == nil {
+		return nil
+	}
+
+	var ground Ground
+	err = json.Unmarshal(groundAsBytes, &ground)
+	if err != nil {
+		return fmt.Errorf("ground Unmarshal Error: %s", err.Error())
+	}
+
+	if ground.OwnerMSP == "" {
+		return nil
+	}
+
+	policy, err := ownerEndorsementPolicy(ground.OwnerMSP)
+	if err != nil {
+		return err
+	}
+
+	return setEndorsement(ctx, policy, keys...)
+}
+
+// reservationKeys returns the key of the reservation and of its indexes
+func reservationKeys(ctx contractapi.TransactionContextInterface, reservation *Reservation) []string {
+	reservationCompositeKey, _ := ctx.GetStub().CreateCompositeKey("reservation", []string{reservation.GroundID, reservation.UserID, reservation.ReservationNumber})
+	numberIndexKey, _ := ctx.GetStub().CreateCompositeKey("reservationNumber", []string{reservation.ReservationNumber})
+	userIndexKey, _ := ctx.GetStub().CreateCompositeKey("userReservation", []string{reservation.UserID, reservation.ReservationNumber})
+	dayIndexKey, _ := ctx.GetStub().CreateCompositeKey("groundDayReservation", []string{reservation.GroundID, reservation.playDay(), reservation.ReservationNumber})
+
+	return []string{reservationCompositeKey, numberIndexKey, userIndexKey, dayIndexKey}
+}
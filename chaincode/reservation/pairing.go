@@ -0,0 +1,545 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// scoreChaincode is the name of the score chaincode on the channel, which keeps the handicap indexes
+const scoreChaincode = "score"
+
+// noHandicapCode is the rejection code the score chaincode answers for a golfer without a handicap index
+const noHandicapCode = "HANDICAP_NOT_FOUND"
+
+// defaultPairingSpread is the widest handicap spread of a group when a golfer states none
+const defaultPairingSpread = 10.0
+
+// PairingRequest is the struct that puts a golfer on the list of golfers waiting for a game on a day.
+// EarliestHour and LatestHour bound the hour the golfer would tee off at, both included.
+// MaxSpread is the widest gap between the handicaps of a group the golfer accepts,
+// and SameGender asks for a group of the golfer's own gender.
+type PairingRequest struct {
+	GroundID      string  `json:"groundID"`
+	Day           string  `json:"day"`
+	UserID        string  `json:"userID"`
+	Gender        string  `json:"gender,omitempty" metadata:"gender,optional"`
+	SameGender    bool    `json:"sameGender"`
+	EarliestHour  uint    `json:"earliestHour"`
+	LatestHour    uint    `json:"latestHour"`
+	MaxSpread     float64 `json:"maxSpread"`
+	SchemaVersion uint    `json:"schemaVersion"`
+}
+
+// PairingGroup is the struct that proposes a foursome and the hours all of its golfers accept
+type PairingGroup struct {
+	UserIDs      []string  `json:"userIDs"`
+	Handicaps    []float64 `json:"handicaps"`
+	Spread       float64   `json:"spread"`
+	EarliestHour uint      `json:"earliestHour"`
+	LatestHour   uint      `json:"latestHour"`
+}
+
+// PairingJoin is the struct that proposes an open tee time to a golfer left without a foursome
+type PairingJoin struct {
+	UserID            string  `json:"userID"`
+	Handicap          float64 `json:"handicap"`
+	ReservationNumber string  `json:"reservationNumber"`
+}
+
+// PairingSuggestions is the struct that returns the suggestions for the golfers waiting on a day.
+// Unmatched lists the golfers who fit no group or open tee time, including those without a handicap index.
+type PairingSuggestions struct {
+	GroundID  string          `json:"groundID"`
+	Day       string          `json:"day"`
+	Groups    []*PairingGroup `json:"groups"`
+	Joins     []*PairingJoin  `json:"joins"`
+	Unmatched []string        `json:"unmatched"`
+}
+
+// pairingCandidate is a waiting golfer with the handicap index read from the score chaincode
+type pairingCandidate struct {
+	request  *PairingRequest
+	handicap float64
+}
+
+// RequestPairing is the invoke function that puts a golfer on the list of golfers waiting for a game.
+// Requesting again replaces the preferences.
+// params - groundID, day(2006-01-02), userID, JSON of the preferences (gender, sameGender, earliestHour, latestHour, maxSpread; may be empty)
+func (s *SmartContract) RequestPairing(ctx contractapi.TransactionContextInterface, groundID string, day string, userID string, preferences string) error {
+	fmt.Println("RequestPairing called")
+
+	request := new(PairingRequest)
+	if preferences != "" {
+		err := json.Unmarshal([]byte(preferences), request)
+		if err != nil {
+			return newBookingError(ErrCodeInvalidRequest, "preferences are not valid JSON. %s", err.Error())
+		}
+	}
+	request.GroundID = groundID
+	request.Day = day
+	request.UserID = userID
+
+	if userID == "" {
+		return newBookingError(ErrCodeInvalidRequest, "userID must not be empty")
+	}
+	if request.Gender != "" && request.Gender != GenderMale && request.Gender != GenderFemale {
+		return newBookingError(ErrCodeInvalidRequest, "%s is not a gender", request.Gender)
+	}
+	if request.SameGender && request.Gender == "" {
+		return newBookingError(ErrCodeInvalidRequest, "sameGender needs the gender of the golfer")
+	}
+
+	// no hours means any hour of the day
+	if request.LatestHour == 0 {
+		request.LatestHour = 23
+	}
+	if request.EarliestHour > request.LatestHour || request.LatestHour > 23 {
+		return newBookingError(ErrCodeInvalidRequest, "hours %d-%d are not a valid window", request.EarliestHour, request.LatestHour)
+	}
+
+	if request.MaxSpread < 0 {
+		return newBookingError(ErrCodeInvalidRequest, "maxSpread must not be negative")
+	}
+	if request.MaxSpread == 0 {
+		request.MaxSpread = defaultPairingSpread
+	}
+
+	_, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	playDay, err := time.Parse(dayFormat, day)
+	if err != nil {
+		return newBookingError(ErrCodeInvalidRequest, "day must be a day like 2006-01-02")
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if playDay.Format(dayFormat) < txTime.Format(dayFormat) {
+		return newBookingError(ErrCodeAlreadyStarted, "%s has passed", day)
+	}
+
+	return putPairingRequest(ctx, request)
+}
+
+// WithdrawPairing is the invoke function that takes a golfer off the list of golfers waiting for a game
+// params - groundID, day(2006-01-02), userID
+func (s *SmartContract) WithdrawPairing(ctx contractapi.TransactionContextInterface, groundID string, day string, userID string) error {
+	fmt.Println("WithdrawPairing called")
+
+	request, err := getPairingRequest(ctx, groundID, day, userID)
+	if err != nil {
+		return err
+	}
+	if request == nil {
+		return newBookingError(ErrCodePairingNotFound, "%s is not waiting for a game on %s", userID, day)
+	}
+
+	return deletePairingRequest(ctx, groundID, day, userID)
+}
+
+// SuggestPairings is the query function that proposes foursomes from the golfers waiting for a game on a day.
+// The handicap indexes are read from the score chaincode. The golfers are taken in order of handicap,
+// so a group gathers the closest handicaps that stay within the spread every member accepts,
+// share an hour to tee off and respect a request for the same gender.
+// Golfers left over are offered open tee times of the day they may join.
+// The same ledger always gives the same suggestions.
+// params - groundID, day(2006-01-02)
+// returns the PairingSuggestions
+func (s *SmartContract) SuggestPairings(ctx contractapi.TransactionContextInterface, groundID string, day string) (*PairingSuggestions, error) {
+	_, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return nil, newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	_, err = time.Parse(dayFormat, day)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "day must be a day like 2006-01-02")
+	}
+
+	requests, err := queryPairingRequests(ctx, groundID, day)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := &PairingSuggestions{
+		GroundID:  groundID,
+		Day:       day,
+		Groups:    []*PairingGroup{},
+		Joins:     []*PairingJoin{},
+		Unmatched: []string{},
+	}
+
+	var candidates []*pairingCandidate
+	for _, request := range requests {
+		handicap, ok, err := queryHandicapIndex(ctx, request.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			suggestions.Unmatched = append(suggestions.Unmatched, request.UserID)
+			continue
+		}
+
+		candidates = append(candidates, &pairingCandidate{request: request, handicap: handicap})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].handicap != candidates[j].handicap {
+			return candidates[i].handicap < candidates[j].handicap
+		}
+		return candidates[i].request.UserID < candidates[j].request.UserID
+	})
+
+	paired := make(map[*pairingCandidate]bool)
+	for i, first := range candidates {
+		if paired[first] {
+			continue
+		}
+
+		group := []*pairingCandidate{first}
+		for _, candidate := range candidates[i+1:] {
+			if len(group) == maxGroupSize {
+				break
+			}
+			if !paired[candidate] && fitsGroup(group, candidate) {
+				group = append(group, candidate)
+			}
+		}
+
+		// only full foursomes are proposed; the others wait for a tee time to join
+		if len(group) < maxGroupSize {
+			continue
+		}
+
+		pairingGroup := &PairingGroup{
+			LatestHour: 23,
+		}
+		for _, member := range group {
+			paired[member] = true
+			pairingGroup.UserIDs = append(pairingGroup.UserIDs, member.request.UserID)
+			pairingGroup.Handicaps = append(pairingGroup.Handicaps, member.handicap)
+			if member.request.EarliestHour > pairingGroup.EarliestHour {
+				pairingGroup.EarliestHour = member.request.EarliestHour
+			}
+			if member.request.LatestHour < pairingGroup.LatestHour {
+				pairingGroup.LatestHour = member.request.LatestHour
+			}
+		}
+		pairingGroup.Spread = group[len(group)-1].handicap - group[0].handicap
+
+		suggestions.Groups = append(suggestions.Groups, pairingGroup)
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dayReservations, err := queryDayReservations(ctx, groundID, day)
+	if err != nil {
+		return nil, err
+	}
+
+	var openReservations []*Reservation
+	for _, reservation := range dayReservations {
+		if reservation.Open != nil && reservation.currentStatus() == StatusBooked && txTime.Before(reservation.Begin) {
+			openReservations = append(openReservations, reservation)
+		}
+	}
+	sort.SliceStable(openReservations, func(i, j int) bool {
+		if !openReservations[i].Begin.Equal(openReservations[j].Begin) {
+			return openReservations[i].Begin.Before(openReservations[j].Begin)
+		}
+		return openReservations[i].ReservationNumber < openReservations[j].ReservationNumber
+	})
+
+	// the spots proposed so far, so a tee time is not offered to more golfers than it has room for
+	proposed := make(map[*Reservation]uint)
+	for _, candidate := range candidates {
+		if paired[candidate] {
+			continue
+		}
+
+		var join *Reservation
+		for _, reservation := range openReservations {
+			if proposed[reservation] < reservation.Open.Spots && fitsOpenTeeTime(candidate, reservation) {
+				join = reservation
+				break
+			}
+		}
+
+		if join == nil {
+			suggestions.Unmatched = append(suggestions.Unmatched, candidate.request.UserID)
+			continue
+		}
+
+		proposed[join]++
+		suggestions.Joins = append(suggestions.Joins, &PairingJoin{
+			UserID:            candidate.request.UserID,
+			Handicap:          candidate.handicap,
+			ReservationNumber: join.ReservationNumber,
+		})
+	}
+
+	return suggestions, nil
+}
+
+// ConfirmPairing is the invoke function that books a suggested group into a reservation.
+// The first golfer holds the reservation and the others become its participants;
+// the tee time must fall within the hours of every golfer, and their pairing requests are removed.
+// The booking policy of the ground applies to every golfer.
+// The owner of the ground or any golfer of the group may confirm a pairing.
+// params - groundID, day(2006-01-02), JSON array of the userIDs, begin and end time of the play
+func (s *SmartContract) ConfirmPairing(ctx contractapi.TransactionContextInterface, groundID string, day string, userIDs string, begin string, end string) error {
+	fmt.Println("ConfirmPairing called")
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	var users []string
+	err = json.Unmarshal([]byte(userIDs), &users)
+	if err != nil {
+		return newBookingError(ErrCodeInvalidRequest, "userIDs are not a valid JSON array. %s", err.Error())
+	}
+	if len(users) < 2 || len(users) > maxGroupSize {
+		return newBookingError(ErrCodeInvalidRequest, "a group has 2 to %d golfers", maxGroupSize)
+	}
+
+	// the client must act for one of the golfers
+	for _, userID := range users {
+		err = requireUserOrOwner(ctx, ground, userID)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	beginTime, err := parseTime(begin)
+	if err != nil {
+		return newBookingError(ErrCodeInvalidRequest, "begin %s", err.Error())
+	}
+	if beginTime.Format(dayFormat) != day {
+		return newBookingError(ErrCodeInvalidRequest, "begin is not on %s", day)
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range users {
+		request, err := getPairingRequest(ctx, groundID, day, userID)
+		if err != nil {
+			return err
+		}
+		if request == nil {
+			return newBookingError(ErrCodePairingNotFound, "%s is not waiting for a game on %s", userID, day)
+		}
+
+		hour := uint(beginTime.Hour())
+		if hour < request.EarliestHour || hour > request.LatestHour {
+			return newBookingError(ErrCodeInvalidRequest, "%s tees off from %d to %d o'clock", userID, request.EarliestHour, request.LatestHour)
+		}
+
+		// reserve checks the policy for the first golfer, who holds the reservation
+		if userID == users[0] {
+			continue
+		}
+
+		tier, err := userTier(ctx, groundID, userID, txTime)
+		if err != nil {
+			return err
+		}
+
+		err = checkBookingPolicy(ctx, groundID, userID, tier, beginTime, uint(len(users)), txTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = s.reserve(ctx, groundID, users[0], begin, end, &ReservationOptions{
+		Players:      uint(len(users)),
+		Participants: users,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range users {
+		err = deletePairingRequest(ctx, groundID, day, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fitsGroup reports whether the candidate, whose handicap is not below any member's, may join the group
+func fitsGroup(group []*pairingCandidate, candidate *pairingCandidate) bool {
+	spread := candidate.handicap - group[0].handicap
+	earliestHour := candidate.request.EarliestHour
+	latestHour := candidate.request.LatestHour
+	sameGender := candidate.request.SameGender
+
+	for _, member := range group {
+		if spread > member.request.MaxSpread {
+			return false
+		}
+		if member.request.EarliestHour > earliestHour {
+			earliestHour = member.request.EarliestHour
+		}
+		if member.request.LatestHour < latestHour {
+			latestHour = member.request.LatestHour
+		}
+		sameGender = sameGender || member.request.SameGender
+	}
+
+	if spread > candidate.request.MaxSpread || earliestHour > latestHour {
+		return false
+	}
+
+	if sameGender {
+		for _, member := range group {
+			if member.request.Gender == "" || member.request.Gender != candidate.request.Gender {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// fitsOpenTeeTime reports whether the candidate may join the open tee time at an hour they accept
+func fitsOpenTeeTime(candidate *pairingCandidate, reservation *Reservation) bool {
+	hour := uint(reservation.Begin.Hour())
+	if hour < candidate.request.EarliestHour || hour > candidate.request.LatestHour {
+		return false
+	}
+
+	if candidate.request.SameGender && reservation.Open.Gender != candidate.request.Gender {
+		return false
+	}
+
+	return reservation.Open.admits(candidate.handicap, candidate.request.Gender)
+}
+
+// queryHandicapIndex reads the handicap index of the golfer from the score chaincode
+// returns false when the score chaincode has no index for the golfer, and an error when it cannot be read
+func queryHandicapIndex(ctx contractapi.TransactionContextInterface, userID string) (float64, bool, error) {
+	args := [][]byte{[]byte("QueryHandicapIndex"), []byte(userID)}
+
+	// an empty channel name reads the score chaincode on the channel of this transaction
+	response := ctx.GetStub().InvokeChaincode(scoreChaincode, args, "")
+	if response.Status != shim.OK {
+		if strings.HasPrefix(response.Message, noHandicapCode+":") {
+			return 0, false, nil
+		}
+
+		return 0, false, fmt.Errorf("Failed to read the handicap index of %s. %s", userID, response.Message)
+	}
+
+	handicapIndex := new(struct {
+		Index float64 `json:"index"`
+	})
+	err := json.Unmarshal(response.Payload, handicapIndex)
+	if err != nil {
+		return 0, false, fmt.Errorf("handicapIndex Unmarshal Error: %s", err.Error())
+	}
+
+	return handicapIndex.Index, true, nil
+}
+
+// queryPairingRequests returns the golfers waiting for a game on the day, in order of userID
+func queryPairingRequests(ctx contractapi.TransactionContextInterface, groundID string, day string) ([]*PairingRequest, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("pairingRequest", []string{groundID, day})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var requests []*PairingRequest
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		request := new(PairingRequest)
+		err = json.Unmarshal(queryResponse.Value, request)
+		if err != nil {
+			return nil, fmt.Errorf("pairingRequest Unmarshal Error: %s", err.Error())
+		}
+
+		requests = append(requests, request)
+	}
+
+	return requests, nil
+}
+
+// getPairingRequest reads the pairing request of the golfer
+// returns nil without an error when the golfer is not waiting on the day
+func getPairingRequest(ctx contractapi.TransactionContextInterface, groundID string, day string, userID string) (*PairingRequest, error) {
+	requestCompositeKey, _ := ctx.GetStub().CreateCompositeKey("pairingRequest", []string{groundID, day, userID})
+	requestAsBytes, err := ctx.GetStub().GetState(requestCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if requestAsBytes == nil {
+		return nil, nil
+	}
+
+	request := new(PairingRequest)
+	err = json.Unmarshal(requestAsBytes, request)
+	if err != nil {
+		return nil, fmt.Errorf("pairingRequest Unmarshal Error: %s", err.Error())
+	}
+
+	return request, nil
+}
+
+// putPairingRequest writes the pairing request
+func putPairingRequest(ctx contractapi.TransactionContextInterface, request *PairingRequest) error {
+	request.SchemaVersion = schemaVersions["pairingRequest"]
+
+	requestCompositeKey, _ := ctx.GetStub().CreateCompositeKey("pairingRequest", []string{request.GroundID, request.Day, request.UserID})
+	requestAsBytes, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("pairingRequest Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(requestCompositeKey, requestAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, request.GroundID, requestCompositeKey)
+}
+
+// deletePairingRequest deletes the pairing request of the golfer, if any
+func deletePairingRequest(ctx contractapi.TransactionContextInterface, groundID string, day string, userID string) error {
+	requestCompositeKey, _ := ctx.GetStub().CreateCompositeKey("pairingRequest", []string{groundID, day, userID})
+
+	return ctx.GetStub().DelState(requestCompositeKey)
+}
@@ -0,0 +1,303 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TierVisitor is the booking tier of a golfer without a membership
+const TierVisitor = "visitor"
+
+// defaultPlayers is the group size assumed when a reservation does not state it
+const defaultPlayers = 4
+
+// BookingPolicy is the struct that describes the booking rules of a ground.
+// AdvanceDays is keyed by booking tier, TierVisitor being the public window.
+// A tier without an entry falls back to the visitor window, and no visitor entry means no limit.
+// MaxActiveBookings of 0 means no limit.
+// TransfersDisabled stops golfers from handing their reservations to each other.
+// BlockUnpaidShares stops golfers who still owe a share of a round already played from booking.
+type BookingPolicy struct {
+	GroundID          string           `json:"groundID"`
+	AdvanceDays       map[string]uint  `json:"advanceDays"`
+	MaxActiveBookings uint             `json:"maxActiveBookings"`
+	GroupSizeRules    []*GroupSizeRule `json:"groupSizeRules"`
+	TransfersDisabled bool             `json:"transfersDisabled"`
+	BlockUnpaidShares bool             `json:"blockUnpaidShares"`
+	SchemaVersion     uint             `json:"schemaVersion"`
+}
+
+// GroupSizeRule is the struct that requires a minimum group size for a time band.
+// Weekdays are the English day names ("Saturday"), and an empty list means every day.
+// The band covers play starting from StartHour up to, but not including, EndHour.
+type GroupSizeRule struct {
+	Weekdays   []string `json:"weekdays"`
+	StartHour  uint     `json:"startHour"`
+	EndHour    uint     `json:"endHour"`
+	MinPlayers uint     `json:"minPlayers"`
+}
+
+// SetBookingPolicy is the invoke function that creates or replaces the booking policy of a ground.
+// Only the owner of the ground may set it.
+// params - groundID, JSON of the BookingPolicy
+func (s *SmartContract) SetBookingPolicy(ctx contractapi.TransactionContextInterface, groundID string, policy string) error {
+	fmt.Println("SetBookingPolicy called")
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireGroundOwner(ctx, ground)
+	if err != nil {
+		return err
+	}
+
+	bookingPolicy := new(BookingPolicy)
+	err = json.Unmarshal([]byte(policy), bookingPolicy)
+	if err != nil {
+		return newBookingError(ErrCodePolicyInvalid, "policy is not valid JSON. %s", err.Error())
+	}
+	bookingPolicy.GroundID = groundID
+
+	err = validateBookingPolicy(bookingPolicy)
+	if err != nil {
+		return err
+	}
+	bookingPolicy.fillEmpty()
+
+	return putBookingPolicy(ctx, bookingPolicy)
+}
+
+// QueryBookingPolicy returns the booking policy of the ground
+// params - groundID
+// returns the BookingPolicy
+func (s *SmartContract) QueryBookingPolicy(ctx contractapi.TransactionContextInterface, groundID string) (*BookingPolicy, error) {
+	bookingPolicy, err := getBookingPolicy(ctx, groundID)
+	if err != nil {
+		return nil, err
+	}
+
+	if bookingPolicy == nil {
+		return nil, fmt.Errorf("booking policy of %s does not exist", groundID)
+	}
+
+	return bookingPolicy, nil
+}
+
+// getBookingPolicy reads the booking policy of the ground
+// returns nil without an error when the ground has no policy
+func getBookingPolicy(ctx contractapi.TransactionContextInterface, groundID string) (*BookingPolicy, error) {
+	policyCompositeKey, _ := ctx.GetStub().CreateCompositeKey("bookingPolicy", []string{groundID})
+	policyAsBytes, err := ctx.GetStub().GetState(policyCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if policyAsBytes == nil {
+		return nil, nil
+	}
+
+	bookingPolicy := new(BookingPolicy)
+	err = json.Unmarshal(policyAsBytes, bookingPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("bookingPolicy Unmarshal Error: %s", err.Error())
+	}
+	bookingPolicy.fillEmpty()
+
+	return bookingPolicy, nil
+}
+
+// putBookingPolicy writes the booking policy to the world state
+func putBookingPolicy(ctx contractapi.TransactionContextInterface, bookingPolicy *BookingPolicy) error {
+	bookingPolicy.SchemaVersion = schemaVersions["bookingPolicy"]
+
+	policyCompositeKey, _ := ctx.GetStub().CreateCompositeKey("bookingPolicy", []string{bookingPolicy.GroundID})
+	policyAsBytes, err := json.Marshal(bookingPolicy)
+	if err != nil {
+		return fmt.Errorf("bookingPolicy Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(policyCompositeKey, policyAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, bookingPolicy.GroundID, policyCompositeKey)
+}
+
+// fillEmpty replaces the missing lists and windows of the policy with empty ones,
+// as the contract metadata does not accept null for them
+func (bookingPolicy *BookingPolicy) fillEmpty() {
+	if bookingPolicy.AdvanceDays == nil {
+		bookingPolicy.AdvanceDays = map[string]uint{}
+	}
+	if bookingPolicy.GroupSizeRules == nil {
+		bookingPolicy.GroupSizeRules = []*GroupSizeRule{}
+	}
+	for _, rule := range bookingPolicy.GroupSizeRules {
+		if rule.Weekdays == nil {
+			rule.Weekdays = []string{}
+		}
+	}
+}
+
+// validateBookingPolicy checks that every rule of the policy can be evaluated
+func validateBookingPolicy(bookingPolicy *BookingPolicy) error {
+	for _, rule := range bookingPolicy.GroupSizeRules {
+		if rule == nil {
+			return newBookingError(ErrCodePolicyInvalid, "group size rule must not be null")
+		}
+		if rule.StartHour >= rule.EndHour || rule.EndHour > 24 {
+			return newBookingError(ErrCodePolicyInvalid, "group size rule hours %d-%d are not a valid band", rule.StartHour, rule.EndHour)
+		}
+		if rule.MinPlayers == 0 {
+			return newBookingError(ErrCodePolicyInvalid, "group size rule needs minPlayers")
+		}
+		for _, weekday := range rule.Weekdays {
+			if _, ok := parseWeekday(weekday); !ok {
+				return newBookingError(ErrCodePolicyInvalid, "%s is not a weekday", weekday)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkBookingPolicy is the function that applies the booking policy of the ground to a reservation request
+// params - groundID, userID, booking tier of the user, begin time, number of players, transaction time
+func checkBookingPolicy(ctx contractapi.TransactionContextInterface, groundID, userID, tier string, beginTime time.Time, players uint, txTime time.Time) error {
+	bookingPolicy, err := getBookingPolicy(ctx, groundID)
+	if err != nil {
+		return err
+	}
+
+	if bookingPolicy == nil {
+		return nil
+	}
+
+	err = bookingPolicy.checkTime(tier, beginTime, players, txTime)
+	if err != nil {
+		return err
+	}
+
+	if bookingPolicy.MaxActiveBookings > 0 {
+		activeBookings, err := countActiveBookings(ctx, groundID, userID, txTime)
+		if err != nil {
+			return err
+		}
+		if activeBookings >= bookingPolicy.MaxActiveBookings {
+			return newBookingError(ErrCodePolicyMaxActive, "%s already holds %d future bookings", userID, activeBookings)
+		}
+	}
+
+	if bookingPolicy.BlockUnpaidShares {
+		overdue, err := hasOverdueShares(ctx, userID, txTime)
+		if err != nil {
+			return err
+		}
+		if overdue {
+			return newBookingError(ErrCodePolicyUnpaidShares, "%s has not paid the share of a round already played", userID)
+		}
+	}
+
+	return nil
+}
+
+// checkTime applies the rules of the policy that depend on the tee time: its advance window and group size
+func (bookingPolicy *BookingPolicy) checkTime(tier string, beginTime time.Time, players uint, txTime time.Time) error {
+	releaseTime, limited := bookingPolicy.releaseTime(tier, beginTime)
+	if limited && txTime.Before(releaseTime) {
+		return newBookingError(ErrCodePolicyAdvanceWindow, "that time opens to %s bookings at %s", tier, releaseTime.Format(time.RFC3339))
+	}
+
+	for _, rule := range bookingPolicy.GroupSizeRules {
+		if rule.appliesTo(beginTime) && players < rule.MinPlayers {
+			return newBookingError(ErrCodePolicyMinGroupSize, "%d players or more are required at that time", rule.MinPlayers)
+		}
+	}
+
+	return nil
+}
+
+// releaseTime returns the time from which the tier may book play starting at beginTime.
+// The tier falls back to the public window, and limited is false when no window applies.
+func (bookingPolicy *BookingPolicy) releaseTime(tier string, beginTime time.Time) (time.Time, bool) {
+	advanceDays, ok := bookingPolicy.AdvanceDays[tier]
+	if !ok {
+		advanceDays, ok = bookingPolicy.AdvanceDays[TierVisitor]
+	}
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return beginTime.AddDate(0, 0, -int(advanceDays)), true
+}
+
+// countActiveBookings returns the number of reservations of the user on the ground that have not been played yet
+func countActiveBookings(ctx contractapi.TransactionContextInterface, groundID, userID string, txTime time.Time) (uint, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("reservation", []string{groundID, userID})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	var count uint
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return 0, err
+		}
+
+		reservation, err := unmarshalReservation(queryResponse.Value)
+		if err != nil {
+			return 0, err
+		}
+
+		if reservation.currentStatus() == StatusBooked && reservation.End.After(txTime) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// appliesTo reports whether play starting at beginTime falls inside the band of the rule
+func (rule *GroupSizeRule) appliesTo(beginTime time.Time) bool {
+	hour := uint(beginTime.Hour())
+	if hour < rule.StartHour || hour >= rule.EndHour {
+		return false
+	}
+
+	if len(rule.Weekdays) == 0 {
+		return true
+	}
+
+	for _, weekday := range rule.Weekdays {
+		if day, _ := parseWeekday(weekday); day == beginTime.Weekday() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseWeekday converts the English day name to time.Weekday
+func parseWeekday(name string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if day.String() == name {
+			return day, true
+		}
+	}
+
+	return time.Sunday, false
+}
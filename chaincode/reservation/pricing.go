@@ -0,0 +1,290 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// defaultHorizonDays is how far ahead dynamic prices apply when the policy does not say
+const defaultHorizonDays = 7
+
+// PricingPolicy is the struct that adjusts the rate table price of a ground to its occupancy.
+// It applies to play within HorizonDays of the booking. Occupancy is counted from the tee slots held
+// in windows of WindowHours from the opening of the ground, or over the whole day when WindowHours is 0.
+// The step of the Curve with the highest MinOccupancy not above the occupancy sets the adjustment,
+// and the adjusted unit price is kept between Floor and Ceiling (0 means no ceiling).
+type PricingPolicy struct {
+	GroundID      string           `json:"groundID"`
+	HorizonDays   uint             `json:"horizonDays"`
+	WindowHours   uint             `json:"windowHours"`
+	Floor         uint             `json:"floor"`
+	Ceiling       uint             `json:"ceiling"`
+	Curve         []*OccupancyStep `json:"curve"`
+	SchemaVersion uint             `json:"schemaVersion"`
+}
+
+// OccupancyStep is the struct that adjusts the price by a percentage from an occupancy percentage upward
+type OccupancyStep struct {
+	MinOccupancy  uint `json:"minOccupancy"`
+	AdjustPercent int  `json:"adjustPercent"`
+}
+
+// SetPricingPolicy is the invoke function that creates or replaces the dynamic pricing of a ground.
+// Only the owner of the ground may set it.
+// params - groundID, JSON of the PricingPolicy
+func (s *SmartContract) SetPricingPolicy(ctx contractapi.TransactionContextInterface, groundID string, policy string) error {
+	fmt.Println("SetPricingPolicy called")
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireGroundOwner(ctx, ground)
+	if err != nil {
+		return err
+	}
+
+	pricingPolicy := new(PricingPolicy)
+	err = json.Unmarshal([]byte(policy), pricingPolicy)
+	if err != nil {
+		return newBookingError(ErrCodePolicyInvalid, "policy is not valid JSON. %s", err.Error())
+	}
+	pricingPolicy.GroundID = groundID
+
+	if pricingPolicy.HorizonDays == 0 {
+		pricingPolicy.HorizonDays = defaultHorizonDays
+	}
+	if pricingPolicy.WindowHours > 24 {
+		return newBookingError(ErrCodePolicyInvalid, "windowHours must be at most 24")
+	}
+	if pricingPolicy.Ceiling != 0 && pricingPolicy.Ceiling < pricingPolicy.Floor {
+		return newBookingError(ErrCodePolicyInvalid, "ceiling must not be below floor")
+	}
+	if pricingPolicy.Curve == nil {
+		pricingPolicy.Curve = []*OccupancyStep{}
+	}
+	for i, step := range pricingPolicy.Curve {
+		if step == nil || step.MinOccupancy > 100 || step.AdjustPercent < -100 {
+			return newBookingError(ErrCodePolicyInvalid, "curve step %d is not valid", i)
+		}
+		if i > 0 && step.MinOccupancy <= pricingPolicy.Curve[i-1].MinOccupancy {
+			return newBookingError(ErrCodePolicyInvalid, "curve steps must rise in minOccupancy")
+		}
+	}
+
+	return putPricingPolicy(ctx, pricingPolicy)
+}
+
+// QueryPricingPolicy returns the dynamic pricing of the ground
+// params - groundID
+// returns the PricingPolicy
+func (s *SmartContract) QueryPricingPolicy(ctx contractapi.TransactionContextInterface, groundID string) (*PricingPolicy, error) {
+	pricingPolicy, err := getPricingPolicy(ctx, groundID)
+	if err != nil {
+		return nil, err
+	}
+
+	if pricingPolicy == nil {
+		return nil, fmt.Errorf("pricing policy of %s does not exist", groundID)
+	}
+
+	return pricingPolicy, nil
+}
+
+// QueryPriceQuote is the query function that prices a tee time as a booking made now would be
+// params - groundID, begin time in RFC3339, players
+// returns the PriceQuote
+func (s *SmartContract) QueryPriceQuote(ctx contractapi.TransactionContextInterface, groundID string, begin string, players uint) (*PriceQuote, error) {
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return nil, newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	beginTime, err := parseTime(begin)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "begin %s", err.Error())
+	}
+
+	if players == 0 {
+		players = defaultPlayers
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	quote, err := priceTeeTime(ctx, ground, beginTime, players, txTime)
+	if err != nil {
+		return nil, err
+	}
+
+	if quote == nil {
+		return nil, fmt.Errorf("no rate of %s applies to %s", groundID, begin)
+	}
+
+	return quote, nil
+}
+
+// priceTeeTime prices play starting at beginTime from the rate table and the occupancy of its window at booking time.
+// Every input is on the ledger or in the proposal, so every endorsing peer computes the same quote.
+// The slots of the window are read by the booking, so bookings of the same window conflict with each other.
+// returns nil when no rate applies
+func priceTeeTime(ctx contractapi.TransactionContextInterface, ground *Ground, beginTime time.Time, players uint, txTime time.Time) (*PriceQuote, error) {
+	rateTable, err := getRateTable(ctx, ground.GroundID)
+	if err != nil {
+		return nil, err
+	}
+
+	quote := rateTable.quote(beginTime, players)
+	if quote == nil {
+		return nil, nil
+	}
+
+	pricingPolicy, err := getPricingPolicy(ctx, ground.GroundID)
+	if err != nil {
+		return nil, err
+	}
+
+	if pricingPolicy == nil || beginTime.After(txTime.AddDate(0, 0, int(pricingPolicy.HorizonDays))) {
+		return quote, nil
+	}
+
+	windowBegin, windowEnd := pricingWindow(ground, pricingPolicy.WindowHours, beginTime)
+	quote.Occupancy, err = measureOccupancy(ctx, ground, windowBegin, windowEnd, txTime)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, step := range pricingPolicy.Curve {
+		if step.MinOccupancy <= quote.Occupancy {
+			quote.AdjustPercent = step.AdjustPercent
+		}
+	}
+
+	// integer arithmetic keeps the price the same on every peer
+	unitPrice := int64(quote.BasePrice) * int64(100+quote.AdjustPercent) / 100
+	if unitPrice < int64(pricingPolicy.Floor) {
+		unitPrice = int64(pricingPolicy.Floor)
+	}
+	if pricingPolicy.Ceiling != 0 && unitPrice > int64(pricingPolicy.Ceiling) {
+		unitPrice = int64(pricingPolicy.Ceiling)
+	}
+
+	quote.UnitPrice = uint(unitPrice)
+	quote.Total = quote.UnitPrice * players
+
+	return quote, nil
+}
+
+// openingHours returns the opening and the closing of the ground on the day of the given time
+func openingHours(ground *Ground, dayTime time.Time) (time.Time, time.Time) {
+	opening := time.Date(dayTime.Year(), dayTime.Month(), dayTime.Day(), int(ground.AvailableTimeStart), 0, 0, 0, dayTime.Location())
+	closing := time.Date(dayTime.Year(), dayTime.Month(), dayTime.Day(), int(ground.AvailableTimeEnd), 0, 0, 0, dayTime.Location())
+
+	return opening, closing
+}
+
+// pricingWindow returns the begin and the end of the pricing window around beginTime
+func pricingWindow(ground *Ground, windowHours uint, beginTime time.Time) (time.Time, time.Time) {
+	opening, closing := openingHours(ground, beginTime)
+
+	windowBegin, windowEnd := opening, closing
+	if windowHours > 0 && !beginTime.Before(opening) {
+		window := time.Duration(windowHours) * time.Hour
+		windowBegin = opening.Add(beginTime.Sub(opening) / window * window)
+		windowEnd = windowBegin.Add(window)
+		if windowEnd.After(closing) {
+			windowEnd = closing
+		}
+	}
+
+	return windowBegin, windowEnd
+}
+
+// measureOccupancy returns the percentage of the slots of the window held by reservations, block bookings,
+// closures and live holds. Slots of expired holds count as free.
+func measureOccupancy(ctx contractapi.TransactionContextInterface, ground *Ground, windowBegin, windowEnd time.Time, txTime time.Time) (uint, error) {
+	var held, total uint
+
+	for _, start := range slotStarts(windowBegin, windowEnd) {
+		key, err := slotKey(ctx, teeSlotKey, []string{ground.GroundID}, start)
+		if err != nil {
+			return 0, err
+		}
+
+		holderAsBytes, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to read from world state. %s", err.Error())
+		}
+
+		total++
+		if holderAsBytes == nil {
+			continue
+		}
+
+		hold, isHold, err := holderHold(ctx, ground.GroundID, string(holderAsBytes))
+		if err != nil {
+			return 0, err
+		}
+		if isHold && (hold == nil || !txTime.Before(hold.ExpiresAt)) {
+			continue
+		}
+
+		held++
+	}
+
+	if total == 0 {
+		return 0, nil
+	}
+
+	return held * 100 / total, nil
+}
+
+// putPricingPolicy writes the dynamic pricing of the ground to the world state
+func putPricingPolicy(ctx contractapi.TransactionContextInterface, pricingPolicy *PricingPolicy) error {
+	pricingPolicy.SchemaVersion = schemaVersions["pricingPolicy"]
+
+	policyCompositeKey, _ := ctx.GetStub().CreateCompositeKey("pricingPolicy", []string{pricingPolicy.GroundID})
+	policyAsBytes, err := json.Marshal(pricingPolicy)
+	if err != nil {
+		return fmt.Errorf("pricingPolicy Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(policyCompositeKey, policyAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, pricingPolicy.GroundID, policyCompositeKey)
+}
+
+// getPricingPolicy reads the dynamic pricing of the ground
+// returns nil without an error when the ground has none
+func getPricingPolicy(ctx contractapi.TransactionContextInterface, groundID string) (*PricingPolicy, error) {
+	policyCompositeKey, _ := ctx.GetStub().CreateCompositeKey("pricingPolicy", []string{groundID})
+	policyAsBytes, err := ctx.GetStub().GetState(policyCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if policyAsBytes == nil {
+		return nil, nil
+	}
+
+	pricingPolicy := new(PricingPolicy)
+	err = json.Unmarshal(policyAsBytes, pricingPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("pricingPolicy Unmarshal Error: %s", err.Error())
+	}
+
+	return pricingPolicy, nil
+}
@@ -0,0 +1,176 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// RescheduleReservation is the invoke function that moves a booked reservation to another time on the same ground.
+// The new time is checked as a new booking would be, except that the slots of the reservation itself count as free,
+// and its caddies and carts move with it. The reservation keeps its number, game code, quote and shares.
+// The time given up is offered to the waitlist.
+// params - reservation number, owner's userID, new begin and end time of the play
+func (s *SmartContract) RescheduleReservation(ctx contractapi.TransactionContextInterface, reservationNumber string, userID string, begin string, end string) error {
+	fmt.Println("RescheduleReservation called")
+
+	beginTime, err := parseTime(begin)
+	if err != nil {
+		return newBookingError(ErrCodeInvalidRequest, "begin %s", err.Error())
+	}
+	endTime, err := parseTime(end)
+	if err != nil {
+		return newBookingError(ErrCodeInvalidRequest, "end %s", err.Error())
+	}
+	err = checkPlayTime(beginTime, endTime)
+	if err != nil {
+		return err
+	}
+
+	reservation, err := getReservation(ctx, reservationNumber)
+	if err != nil {
+		return err
+	}
+	if reservation == nil {
+		return newBookingError(ErrCodeReservationNotFound, "%s does not exist", reservationNumber)
+	}
+
+	if reservation.UserID != userID {
+		return newBookingError(ErrCodeNotOwner, "%s does not hold %s", userID, reservationNumber)
+	}
+	if reservation.BlockID != "" {
+		return newBookingError(ErrCodeInvalidStatus, "%s belongs to block booking %s", reservationNumber, reservation.BlockID)
+	}
+	if reservation.currentStatus() != StatusBooked {
+		return newBookingError(ErrCodeInvalidStatus, "%s is %s", reservationNumber, reservation.currentStatus())
+	}
+
+	ground, err := s.QueryGround(ctx, reservation.GroundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireUserOrOwner(ctx, ground, userID)
+	if err != nil {
+		return err
+	}
+
+	err = checkOpeningHours(ground, beginTime)
+	if err != nil {
+		return err
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if !txTime.Before(reservation.Begin) {
+		return newBookingError(ErrCodeAlreadyStarted, "%s has already started", reservationNumber)
+	}
+	if !txTime.Before(beginTime) {
+		return newBookingError(ErrCodeAlreadyStarted, "that time has already started")
+	}
+
+	// the booking is not new, so only the rules about the time itself apply
+	bookingPolicy, err := getBookingPolicy(ctx, reservation.GroundID)
+	if err != nil {
+		return err
+	}
+	if bookingPolicy != nil {
+		tier, err := userTier(ctx, reservation.GroundID, userID, txTime)
+		if err != nil {
+			return err
+		}
+
+		err = bookingPolicy.checkTime(tier, beginTime, reservation.Players, txTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	isPossible, err := validateReservation(ctx, reservation.GroundID, beginTime, endTime, reservation.ReservationNumber)
+	if err != nil {
+		return fmt.Errorf("validate Error: %s", err.Error())
+	}
+	if !isPossible {
+		return newBookingError(ErrCodeSlotTaken, "that time is already reserved")
+	}
+
+	err = validateResources(ctx, reservation.GroundID, reservation.Resources, beginTime, endTime, reservation.ReservationNumber)
+	if err != nil {
+		return err
+	}
+
+	err = releaseExpiredHolds(ctx, reservation.GroundID, beginTime, endTime, txTime)
+	if err != nil {
+		return err
+	}
+
+	// the slots of the old time are deleted before those of the new time are written,
+	// so a slot both times share ends up held
+	err = freeSlots(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
+	oldDayIndexKey, _ := ctx.GetStub().CreateCompositeKey("groundDayReservation", []string{reservation.GroundID, reservation.playDay(), reservation.ReservationNumber})
+	err = ctx.GetStub().DelState(oldDayIndexKey)
+	if err != nil {
+		return fmt.Errorf("Failed to delete from world state. %s", err.Error())
+	}
+
+	oldBegin, oldEnd := reservation.Begin, reservation.End
+	reservation.Begin = beginTime
+	reservation.End = endTime
+
+	err = holdSlots(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
+	err = appendHistory(ctx, reservation, HistoryRescheduled, userID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = putReservation(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
+	// a share falls due at the new tee time
+	for _, participant := range reservation.Participants {
+		share, err := getShare(ctx, reservation.GroundID, reservation.ReservationNumber, participant)
+		if err != nil {
+			return err
+		}
+		if share == nil {
+			continue
+		}
+
+		share.Begin = beginTime
+		err = putShare(ctx, share)
+		if err != nil {
+			return err
+		}
+	}
+
+	events := new(eventBatch)
+	err = events.add(EventReservationRescheduled, reservation)
+	if err != nil {
+		return err
+	}
+
+	// the new time is held by the reservation, though the world state still shows its old slots
+	kept := []playTime{{begin: beginTime, end: endTime}}
+	err = promoteWaitlist(ctx, reservation.GroundID, reservation.ReservationNumber, oldBegin, oldEnd, kept, events)
+	if err != nil {
+		return err
+	}
+
+	return events.emit(ctx)
+}
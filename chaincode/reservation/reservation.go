@@ -5,11 +5,12 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
-	"strconv"
 	"time"
-	"math/rand"
+
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
@@ -20,11 +21,20 @@ type SmartContract struct {
 
 // Ground information
 type Ground struct {
-	GroundID           string `json:"groundID"`
-	GroundName         string `json:"groundName"`
-	AvailableTimeStart uint   `json:"availableTimeStart"`
-	AvailableTimeEnd   uint   `json:"availableTimeEnd"`
-	TotalHole          uint   `json:"totalHole"`
+	GroundID           string    `json:"groundID"`
+	GroundName         string    `json:"groundName"`
+	AvailableTimeStart uint      `json:"availableTimeStart"`
+	AvailableTimeEnd   uint      `json:"availableTimeEnd"`
+	TotalHole          uint      `json:"totalHole"`
+	Region             string    `json:"region"`
+	Address            string    `json:"address"`
+	Latitude           float64   `json:"latitude"`
+	Longitude          float64   `json:"longitude"`
+	Amenities          Amenities `json:"amenities"`
+	Contact            string    `json:"contact"`
+	DocType            string    `json:"docType"`
+	OwnerMSP           string    `json:"ownerMSP,omitempty" metadata:"ownerMSP,optional"`
+	SchemaVersion      uint      `json:"schemaVersion"`
 	// HolesInfo          map[uint]*HoleInfo `json:"holesInfo"`
 }
 
@@ -36,18 +46,56 @@ type Ground struct {
 
 // Reservation is the sturct that desribes the reservation information.
 type Reservation struct {
-	GroundID          string    `json:"groundID"`
-	UserID            string    `json:"userID"`
-	Begin             time.Time `json:"begin"`
-	End               time.Time `json:"end"`
-	ReservationNumber string    `json:"reservationNumber"`
-	GameCode          int		`json:"gameCode"`
+	GroundID          string          `json:"groundID"`
+	UserID            string          `json:"userID"`
+	Begin             time.Time       `json:"begin"`
+	End               time.Time       `json:"end"`
+	ReservationNumber string          `json:"reservationNumber"`
+	GameCode          int             `json:"gameCode"`
+	Players           uint            `json:"players"`
+	BlockID           string          `json:"blockID,omitempty" metadata:"blockID,optional"`
+	StartingHole      uint            `json:"startingHole,omitempty" metadata:"startingHole,optional"`
+	Status            string          `json:"status"`
+	PendingTransferTo string          `json:"pendingTransferTo,omitempty" metadata:"pendingTransferTo,optional"`
+	Resources         []string        `json:"resources,omitempty" metadata:"resources,optional"`
+	Participants      []string        `json:"participants,omitempty" metadata:"participants,optional"`
+	Open              *OpenTeeTime    `json:"open,omitempty" metadata:"open,optional"`
+	Quote             *PriceQuote     `json:"quote,omitempty" metadata:"quote,optional"`
+	History           []*HistoryEntry `json:"history,omitempty" metadata:"history,optional"`
+	CheckedIn         bool            `json:"checkedIn,omitempty" metadata:"checkedIn,optional"`
+	SchemaVersion     uint            `json:"schemaVersion"`
 }
 
-// ReservationKey is the struct containing a reservation key and index
-type ReservationKey struct {
-	Key string
-	Idx int
+// reservationNumberPrefix starts the number of every reservation
+const reservationNumberPrefix = "RESERVE"
+
+// Status of a reservation
+const (
+	StatusBooked    = "booked"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+)
+
+// Actions recorded in the history of a reservation
+const (
+	HistoryCreated         = "created"
+	HistoryTransferOffered = "transferOffered"
+	HistoryTransferVoided  = "transferVoided"
+	HistoryTransferred     = "transferred"
+	HistoryCompleted       = "completed"
+	HistoryCancelled       = "cancelled"
+	HistoryJoined          = "joined"
+	HistoryRescheduled     = "rescheduled"
+	HistoryCheckedIn       = "checkedIn"
+)
+
+// HistoryEntry is the struct that describes one change of a reservation
+type HistoryEntry struct {
+	Action     string    `json:"action"`
+	FromUserID string    `json:"fromUserID,omitempty" metadata:"fromUserID,optional"`
+	ToUserID   string    `json:"toUserID,omitempty" metadata:"toUserID,optional"`
+	TxID       string    `json:"txID"`
+	Timestamp  time.Time `json:"timestamp"`
 }
 
 // InitLedger adds a base set of grounds to the ledger
@@ -60,10 +108,15 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 		TotalHole:          34,
 		// HolesInfo:          make(map[uint]*HoleInfo),
 	}
-	groundCompositeKey, _ := ctx.GetStub().CreateCompositeKey("ground", []string{"Ground01"})
 
-	groundAsBytes, _ := json.Marshal(ground)
-	err := ctx.GetStub().PutState(groundCompositeKey, groundAsBytes)
+	// the organization initializing the ledger owns the base grounds
+	ownerMSP, err := clientMSP(ctx)
+	if err != nil {
+		return err
+	}
+	ground.OwnerMSP = ownerMSP
+
+	err = putGround(ctx, &ground)
 	if err != nil {
 		return fmt.Errorf("Failed to put the world state. %s", err.Error())
 	}
@@ -71,13 +124,12 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 	return nil
 }
 
-// CreateGround adds a new ground to the world state with given details
+// CreateGround adds a new ground to the world state with given details.
+// The organization of the client owns a new ground; an existing ground keeps its owner, who alone may replace it.
 // params - groundID, grond name, start time, end time, and total hole number
 func (s *SmartContract) CreateGround(ctx contractapi.TransactionContextInterface, groundID string, name string, startTime uint, endTime uint, totalHole uint) error {
 	fmt.Println("CreateGround called")
 
-	// create composite key for the ground
-	groundCompositeKey, _ := ctx.GetStub().CreateCompositeKey("ground", []string{groundID})
 	ground := Ground{
 		GroundID:           groundID,
 		GroundName:         name,
@@ -87,9 +139,21 @@ func (s *SmartContract) CreateGround(ctx contractapi.TransactionContextInterface
 		// HolesInfo:          make(map[uint]*HoleInfo),
 	}
 
-	groundAsBytes, _ := json.Marshal(ground)
+	existing, err := s.QueryGround(ctx, groundID)
+	if err == nil {
+		err = requireGroundOwner(ctx, existing)
+		if err != nil {
+			return err
+		}
+		ground.OwnerMSP = existing.OwnerMSP
+	} else {
+		ground.OwnerMSP, err = clientMSP(ctx)
+		if err != nil {
+			return err
+		}
+	}
 
-	return ctx.GetStub().PutState(groundCompositeKey, groundAsBytes)
+	return putGround(ctx, &ground)
 }
 
 // QueryGround returns the ground stored in the world state with given groundID
@@ -107,10 +171,7 @@ func (s *SmartContract) QueryGround(ctx contractapi.TransactionContextInterface,
 		return nil, fmt.Errorf("%s does not exist", groundID)
 	}
 
-	ground := new(Ground)
-	_ = json.Unmarshal(groundAsBytes, ground)
-
-	return ground, nil
+	return unmarshalGround(groundAsBytes)
 }
 
 // QueryAllGround returns all grounds found in world state
@@ -132,11 +193,12 @@ func (s *SmartContract) QueryAllGround(ctx contractapi.TransactionContextInterfa
 			return nil, err
 		}
 
-		var ground Ground
-
-		_ = json.Unmarshal(queryResponse.Value, &ground)
+		ground, err := unmarshalGround(queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
 
-		grounds = append(grounds, &ground)
+		grounds = append(grounds, ground)
 	}
 
 	return grounds, nil
@@ -145,119 +207,363 @@ func (s *SmartContract) QueryAllGround(ctx contractapi.TransactionContextInterfa
 // parseTime is the parsing funciton
 // params - string of time
 // returns the time object
-func parseTime(timeString string) time.Time {
+func parseTime(timeString string) (time.Time, error) {
 	t, err := time.Parse(time.RFC3339, timeString)
 	if err != nil {
-		fmt.Printf("Parsing Time Erorr!!!!: %s", err.Error())
+		return t, fmt.Errorf("Parsing Time Erorr!!!!: %s", err.Error())
 	}
-	return t
+	return t, nil
+}
+
+// txGameCode derives a game code from the transaction ID, so every endorsing peer draws the same code
+func txGameCode(txID string) int {
+	digest := sha256.Sum256([]byte(txID))
+
+	return int(binary.BigEndian.Uint32(digest[:4]) % 9999)
+}
+
+// ReservationOptions is the struct that carries the optional details of a reservation request
+type ReservationOptions struct {
+	Players      uint     `json:"players"`
+	Resources    []string `json:"resources"`
+	CouponCode   string   `json:"couponCode"`
+	Participants []string `json:"participants"`
+
+	// holdID is the hold a reservation made by ConfirmHold takes over
+	holdID string
+}
+
+// ReserveGround is the invoke function that makes a reservation the ground
+// params - groundID, userID, begin and end time of the play
+func (s *SmartContract) ReserveGround(ctx contractapi.TransactionContextInterface, groundID string, userID string, begin string, end string) error {
+	fmt.Println("ReserveGround called")
+
+	_, err := s.reserve(ctx, groundID, userID, begin, end, new(ReservationOptions))
+	return err
 }
 
-func createRandomCode() int {
-	s1:=rand.NewSource(time.Now().UnixNano())
-	r1:=rand.New(s1)
-	r := r1.Intn(9999)
+// ReserveGroundWithOptions is the invoke function that makes a reservation with optional details
+// params - groundID, userID, begin and end time of the play, JSON of the ReservationOptions
+func (s *SmartContract) ReserveGroundWithOptions(ctx contractapi.TransactionContextInterface, groundID string, userID string, begin string, end string, options string) error {
+	fmt.Println("ReserveGroundWithOptions called")
+
+	reservationOptions := new(ReservationOptions)
+	if options != "" {
+		err := json.Unmarshal([]byte(options), reservationOptions)
+		if err != nil {
+			return newBookingError(ErrCodeInvalidRequest, "options are not valid JSON. %s", err.Error())
+		}
+	}
 
-	return r
+	_, err := s.reserve(ctx, groundID, userID, begin, end, reservationOptions)
+	return err
 }
 
-// GenerateKey is the function that generate Reservation key
-// params - key
-// returns ReservationKey
-func (s *SmartContract) GenerateKey(ctx contractapi.TransactionContextInterface, key string) *ReservationKey {
-	fmt.Println("GenerateKey")
-	var isFirst bool = false
+// reserve is the function that validates a reservation request and writes the Reservation
+// params - groundID, userID, begin and end time of the play, options
+// returns the Reservation
+func (s *SmartContract) reserve(ctx contractapi.TransactionContextInterface, groundID string, userID string, begin string, end string, options *ReservationOptions) (*Reservation, error) {
+	// parse the time
+	beginTime, err := parseTime(begin)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "begin %s", err.Error())
+	}
+	endTime, err := parseTime(end)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "end %s", err.Error())
+	}
+	err = checkPlayTime(beginTime, endTime)
+	if err != nil {
+		return nil, err
+	}
 
-	reservationKeyAsBytes, err := ctx.GetStub().GetState(key)
+	ground, err := s.QueryGround(ctx, groundID)
 	if err != nil {
-		fmt.Println(err.Error())
+		return nil, newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
 	}
-	var reservationKey *ReservationKey
 
-	// initialize ReservationKey
-	if reservationKeyAsBytes == nil {
-		isFirst = true
-		reservationKey = &ReservationKey{
-			Key: "RESERVE",
-			Idx: 1,
-		}
+	err = checkOpeningHours(ground, beginTime)
+	if err != nil {
+		return nil, err
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	players := options.Players
+	if players == 0 {
+		players = defaultPlayers
+	}
+
+	// the other participants each owe the holder the price of one player
+	participants, err := checkParticipants(userID, options.Participants, players)
+	if err != nil {
+		return nil, err
+	}
+
+	tier, err := userTier(ctx, groundID, userID, txTime)
+	if err != nil {
+		return nil, err
+	}
+
+	// check the booking policy of the ground
+	err = checkBookingPolicy(ctx, groundID, userID, tier, beginTime, players, txTime)
+	if err != nil {
+		return nil, err
+	}
+
+	// check the validation
+	ownHolder := ""
+	if options.holdID != "" {
+		ownHolder = holdHolder(options.holdID)
+	}
+	isPossible, err := validateReservation(ctx, groundID, beginTime, endTime, ownHolder)
+	if err != nil {
+		return nil, fmt.Errorf("validate Error: %s", err.Error())
+	}
+	if !isPossible {
+		return nil, newBookingError(ErrCodeSlotTaken, "that time is already reserved")
+	}
+
+	err = releaseExpiredHolds(ctx, groundID, beginTime, endTime, txTime)
+	if err != nil {
+		return nil, err
+	}
+
+	// caddies and carts are booked together with the tee time
+	err = validateResources(ctx, groundID, options.Resources, beginTime, endTime, "")
+	if err != nil {
+		return nil, err
 	}
 
-	var tempIdx string
-	// when exist the key, increase the index
-	if !isFirst {
-		err = json.Unmarshal(reservationKeyAsBytes, &reservationKey)
+	// stamp the green fee of the time, priced on the tee sheet before this booking
+	quote, err := priceTeeTime(ctx, ground, beginTime, players, txTime)
+	if err != nil {
+		return nil, err
+	}
+
+	// the coupon is used up in the same transaction as the booking
+	if options.CouponCode != "" {
+		err = redeemCoupon(ctx, options.CouponCode, ground, userID, beginTime, quote, txTime)
 		if err != nil {
-			fmt.Println(err.Error())
+			return nil, err
 		}
-		tempIdx = strconv.Itoa(reservationKey.Idx)
-		reservationKey.Idx = reservationKey.Idx + 1
 	}
 
-	fmt.Println("Last ReservationKey is " + reservationKey.Key + " : " + tempIdx)
+	// create the Reservation
+	reservation := &Reservation{
+		GroundID:     groundID,
+		UserID:       userID,
+		Begin:        beginTime,
+		End:          endTime,
+		Players:      players,
+		Resources:    options.Resources,
+		Participants: participants,
+		Quote:        quote,
+	}
+
+	err = s.createReservation(ctx, reservation)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createShares(ctx, reservation)
+	if err != nil {
+		return nil, err
+	}
+
+	events := new(eventBatch)
+	err = events.add(EventReservationCreated, reservation)
+	if err != nil {
+		return nil, err
+	}
+
+	err = events.emit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("event Error: %s", err.Error())
+	}
 
-	return reservationKey
+	return reservation, nil
 }
 
-// ReserveGround is the invoke function that makes a reservation the ground
-// params - groundID, userID, begin and end time of the play
-func (s *SmartContract) ReserveGround(ctx contractapi.TransactionContextInterface, groundID string, userID string, begin string, end string)error {
-	fmt.Println("ReserveGround called")
-	var reservationKey *ReservationKey
-	reservationKey = s.GenerateKey(ctx, "latestKey")
-	keyidx := strconv.Itoa(reservationKey.Idx)
-	fmt.Println("Key : " + reservationKey.Key + ", Idx : " + keyidx)
+// createReservation is the function that numbers a new reservation and writes it to the world state with its slot keys.
+// The caller has validated that the slots are free.
+// params - the Reservation, whose reservation number and game code are assigned here
+func (s *SmartContract) createReservation(ctx contractapi.TransactionContextInterface, reservation *Reservation) error {
+	// the transaction ID is unique on the channel, and a transaction creates one reservation at most
+	reservation.ReservationNumber = reservationNumberPrefix + ctx.GetStub().GetTxID()
+	fmt.Println("reservationNumber is " + reservation.ReservationNumber)
 
-	var reservationNumer = reservationKey.Key + keyidx
-	fmt.Println("reservationKey is " + reservationNumer)
+	reservation.GameCode = txGameCode(ctx.GetStub().GetTxID())
+	reservation.Status = StatusBooked
 
-	reservationCompositeKey, _ := ctx.GetStub().CreateCompositeKey("reservation", []string{groundID, userID, reservationNumer})
+	err := appendHistory(ctx, reservation, HistoryCreated, "", reservation.UserID)
+	if err != nil {
+		return err
+	}
 
-	// parse the time
-	beginTime := parseTime(begin)
-	endTime := parseTime(end)
+	err = holdSlots(ctx, reservation)
+	if err != nil {
+		return err
+	}
 
-	randomGameCode := createRandomCode()
+	return putReservation(ctx, reservation)
+}
 
-	// check the validation
-	isPossible, err := validateReservation(ctx, groundID, beginTime, endTime)
-	if err != nil {
-		return fmt.Errorf("validate Error: %s", err.Error())
-	}
-	if isPossible {
-		// create the Reservation
-		reservation := Reservation{
-			GroundID:          groundID,
-			UserID:            userID,
-			Begin:             beginTime,
-			End:               endTime,
-			ReservationNumber: reservationNumer,
-			GameCode:          randomGameCode,
-		}
+// putReservation writes the reservation to the world state under its composite key,
+// along with the indexes by reservation number, by user and by day of play
+func putReservation(ctx contractapi.TransactionContextInterface, reservation *Reservation) error {
+	reservation.SchemaVersion = schemaVersions["reservation"]
+
+	reservationCompositeKey, _ := ctx.GetStub().CreateCompositeKey("reservation", []string{reservation.GroundID, reservation.UserID, reservation.ReservationNumber})
+
+	reservationAsBytes, err := json.Marshal(reservation)
+	if err != nil {
+		return fmt.Errorf("reservation Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(reservationCompositeKey, reservationAsBytes)
+	if err != nil {
+		return fmt.Errorf("Failed to put the world state. %s", err.Error())
+	}
+
+	// the indexes hold the composite key of the reservation
+	numberIndexKey, _ := ctx.GetStub().CreateCompositeKey("reservationNumber", []string{reservation.ReservationNumber})
+	err = ctx.GetStub().PutState(numberIndexKey, []byte(reservationCompositeKey))
+	if err != nil {
+		return fmt.Errorf("Failed to put the world state. %s", err.Error())
+	}
+
+	userIndexKey, _ := ctx.GetStub().CreateCompositeKey("userReservation", []string{reservation.UserID, reservation.ReservationNumber})
+	err = ctx.GetStub().PutState(userIndexKey, []byte(reservationCompositeKey))
+	if err != nil {
+		return fmt.Errorf("Failed to put the world state. %s", err.Error())
+	}
+
+	dayIndexKey, _ := ctx.GetStub().CreateCompositeKey("groundDayReservation", []string{reservation.GroundID, reservation.playDay(), reservation.ReservationNumber})
+	err = ctx.GetStub().PutState(dayIndexKey, []byte(reservationCompositeKey))
+	if err != nil {
+		return fmt.Errorf("Failed to put the world state. %s", err.Error())
+	}
+
+	// only the owner of the ground may change its tee sheet
+	return endorseByOwner(ctx, reservation.GroundID, reservationKeys(ctx, reservation)...)
+}
+
+// delReservation deletes the reservation and its user index from the world state.
+// The index by reservation number is left for putReservation to overwrite.
+func delReservation(ctx contractapi.TransactionContextInterface, reservation *Reservation) error {
+	reservationCompositeKey, _ := ctx.GetStub().CreateCompositeKey("reservation", []string{reservation.GroundID, reservation.UserID, reservation.ReservationNumber})
+	err := ctx.GetStub().DelState(reservationCompositeKey)
+	if err != nil {
+		return fmt.Errorf("Failed to delete from world state. %s", err.Error())
+	}
+
+	userIndexKey, _ := ctx.GetStub().CreateCompositeKey("userReservation", []string{reservation.UserID, reservation.ReservationNumber})
+	err = ctx.GetStub().DelState(userIndexKey)
+	if err != nil {
+		return fmt.Errorf("Failed to delete from world state. %s", err.Error())
+	}
+
+	return nil
+}
+
+// getReservation reads the reservation with the given reservation number.
+// Reservations written before the number index existed are found by scanning.
+// returns nil without an error when it does not exist or was archived
+func getReservation(ctx contractapi.TransactionContextInterface, reservationNumber string) (*Reservation, error) {
+	numberIndexKey, _ := ctx.GetStub().CreateCompositeKey("reservationNumber", []string{reservationNumber})
+	reservationCompositeKey, err := ctx.GetStub().GetState(numberIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if reservationCompositeKey == nil {
+		return scanReservation(ctx, reservationNumber)
+	}
+	if isArchiveKey(ctx, string(reservationCompositeKey)) {
+		return nil, nil
+	}
+
+	reservationAsBytes, err := ctx.GetStub().GetState(string(reservationCompositeKey))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if reservationAsBytes == nil {
+		return nil, nil
+	}
+
+	return unmarshalReservation(reservationAsBytes)
+}
+
+// scanReservation looks for the reservation number through every reservation
+func scanReservation(ctx contractapi.TransactionContextInterface, reservationNumber string) (*Reservation, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("reservation", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
 
-		reservationAsBytes, err := json.Marshal(reservation)
 		if err != nil {
-			return fmt.Errorf("reservation Marshal Error: %s", err.Error())
+			return nil, err
 		}
 
-		err = ctx.GetStub().SetEvent("newReservation", reservationAsBytes)
-		if err != nil {
-			return fmt.Errorf("event Error: %s", err.Error())
+		_, keys, _ := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if len(keys) != 3 || keys[2] != reservationNumber {
+			continue
 		}
 
-		reservationKeyAsBytes, _ := json.Marshal(reservationKey)
-		ctx.GetStub().PutState("latestKey", reservationKeyAsBytes)
+		return unmarshalReservation(queryResponse.Value)
+	}
 
-		ctx.GetStub().PutState(reservationCompositeKey, reservationAsBytes)
-	} else {
-		fmt.Println("That time is already reserved")
-		return nil
+	return nil, nil
+}
+
+// playDay returns the day of play in the offset the reservation was made in, which is the ground's own clock
+func (reservation *Reservation) playDay() string {
+	return reservation.Begin.Format(dayFormat)
+}
+
+// currentStatus returns the status of the reservation.
+// Reservations written before the status existed are booked.
+func (reservation *Reservation) currentStatus() string {
+	if reservation.Status == "" {
+		return StatusBooked
+	}
+
+	return reservation.Status
+}
+
+// appendHistory records a change of the reservation made by the current transaction
+// params - the Reservation, action, the user before and after the change
+func appendHistory(ctx contractapi.TransactionContextInterface, reservation *Reservation, action string, fromUserID string, toUserID string) error {
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
 	}
+
+	reservation.History = append(reservation.History, &HistoryEntry{
+		Action:     action,
+		FromUserID: fromUserID,
+		ToUserID:   toUserID,
+		TxID:       ctx.GetStub().GetTxID(),
+		Timestamp:  txTime,
+	})
+
 	return nil
 }
 
+// UserConfirmReservation is the query function that returns every reservation of the user
+// params - userID
+// returns the array of reservations
 func (s *SmartContract) UserConfirmReservation(ctx contractapi.TransactionContextInterface, userID string) ([]*Reservation, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("reservation", []string{userID})
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("userReservation", []string{userID})
 	if err != nil {
 		return nil, err
 	}
@@ -272,16 +578,96 @@ func (s *SmartContract) UserConfirmReservation(ctx contractapi.TransactionContex
 			return nil, err
 		}
 
-		var reservation Reservation
+		reservationAsBytes, err := ctx.GetStub().GetState(string(queryResponse.Value))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+		}
+
+		if reservationAsBytes == nil {
+			continue
+		}
 
-		_ = json.Unmarshal(queryResponse.Value, &reservation)
+		reservation, err := unmarshalReservation(reservationAsBytes)
+		if err != nil {
+			return nil, err
+		}
 
-		reservations = append(reservations, &reservation)
+		reservations = append(reservations, reservation)
 	}
 
 	return reservations, nil
 }
 
+// CancelReservation is the invoke function that cancels a reservation before its play starts.
+// The reservation stays on the ledger as cancelled and its slots are free again, offered first to the waitlist.
+// params - reservation number, owner's userID
+func (s *SmartContract) CancelReservation(ctx contractapi.TransactionContextInterface, reservationNumber string, userID string) error {
+	fmt.Println("CancelReservation called")
+
+	reservation, err := getReservation(ctx, reservationNumber)
+	if err != nil {
+		return err
+	}
+	if reservation == nil {
+		return newBookingError(ErrCodeReservationNotFound, "%s does not exist", reservationNumber)
+	}
+
+	if reservation.UserID != userID {
+		return newBookingError(ErrCodeNotOwner, "%s does not hold %s", userID, reservationNumber)
+	}
+	if reservation.BlockID != "" {
+		return newBookingError(ErrCodeInvalidStatus, "%s belongs to block booking %s", reservationNumber, reservation.BlockID)
+	}
+	if reservation.currentStatus() != StatusBooked {
+		return newBookingError(ErrCodeInvalidStatus, "%s is %s", reservationNumber, reservation.currentStatus())
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if !txTime.Before(reservation.Begin) {
+		return newBookingError(ErrCodeAlreadyStarted, "%s has already started", reservationNumber)
+	}
+
+	// the time and the caddies and carts can be booked again
+	err = freeSlots(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
+	reservation.Status = StatusCancelled
+	reservation.PendingTransferTo = ""
+	err = appendHistory(ctx, reservation, HistoryCancelled, userID, "")
+	if err != nil {
+		return err
+	}
+
+	err = putReservation(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
+	// nobody owes a share of a round that will not be played
+	err = voidShares(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
+	events := new(eventBatch)
+	err = events.add(EventReservationCancelled, reservation)
+	if err != nil {
+		return err
+	}
+
+	// the freed time is offered to the golfers waiting for it
+	err = promoteWaitlist(ctx, reservation.GroundID, reservation.ReservationNumber, reservation.Begin, reservation.End, nil, events)
+	if err != nil {
+		return err
+	}
+
+	return events.emit(ctx)
+}
 
 // ConfirmReservation is the query function that confirms the reservation status given groundID and userID
 // params - groundID, userID
@@ -302,45 +688,86 @@ func (s *SmartContract) ConfirmReservation(ctx contractapi.TransactionContextInt
 			return nil, err
 		}
 
-		var reservation Reservation
-
-		_ = json.Unmarshal(queryResponse.Value, &reservation)
+		reservation, err := unmarshalReservation(queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
 
-		reservations = append(reservations, &reservation)
+		reservations = append(reservations, reservation)
 	}
 
 	return reservations, nil
 }
 
-// validateReservation is the function that validates the reservation according to given time
-// params - groundID, begin and end time
+// validateReservation is the function that validates the reservation according to given time.
+// It reads the slot keys of the time instead of every reservation of the ground, and those reads
+// make a concurrent booking of the same slot fail the MVCC check.
+// Expired holds are ignored, and so are the slots of ownHolder, which the caller takes over:
+// the hold being confirmed or the reservation being moved.
+// params - groundID, begin and end time, holder of the slots taken over or ""
 // returns the true or false
-func validateReservation(ctx contractapi.TransactionContextInterface, groundID string, beginTime, endTime time.Time) (bool, error) {
+func validateReservation(ctx contractapi.TransactionContextInterface, groundID string, beginTime, endTime time.Time, ownHolder string) (bool, error) {
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, start := range slotStarts(beginTime, endTime) {
+		key, err := slotKey(ctx, teeSlotKey, []string{groundID}, start)
+		if err != nil {
+			return false, err
+		}
+
+		holderAsBytes, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return false, fmt.Errorf("Failed to read from world state. %s", err.Error())
+		}
+
+		holder := string(holderAsBytes)
+		if holder == "" || (ownHolder != "" && holder == ownHolder) {
+			continue
+		}
+
+		hold, isHold, err := holderHold(ctx, groundID, holder)
+		if err != nil {
+			return false, err
+		}
+		if isHold && (hold == nil || !txTime.Before(hold.ExpiresAt)) {
+			continue
+		}
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// queryGroundReservations returns all reservations of the ground
+func queryGroundReservations(ctx contractapi.TransactionContextInterface, groundID string) ([]*Reservation, error) {
 	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("reservation", []string{groundID})
 	if err != nil {
-		return false, fmt.Errorf("%s", err.Error())
+		return nil, err
 	}
 	defer resultsIterator.Close()
 
+	var reservations []*Reservation
+
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 
 		if err != nil {
-			return false, fmt.Errorf("%s", err.Error())
+			return nil, err
 		}
 
-		var reservation Reservation
-
-		_ = json.Unmarshal(queryResponse.Value, &reservation)
-		// if (beginTime.After(reservation.Begin) && beginTime.Before(reservation.End)) || (endTime.After(reservation.Begin) && endTime.Before(reservation.End) || (beginTime.Equal(reservation.Begin) || endTime.Equal(reservation.End))) {
-		// 	return false, nil
-		// }
-		if !(beginTime.After(reservation.End) || endTime.Before(reservation.Begin)) {
-			return false, nil
+		reservation, err := unmarshalReservation(queryResponse.Value)
+		if err != nil {
+			return nil, err
 		}
+
+		reservations = append(reservations, reservation)
 	}
 
-	return true, nil
+	return reservations, nil
 }
 
 // main function
@@ -357,5 +784,4 @@ func main() {
 		fmt.Printf("Error starting fabcar chaincode: %s", err.Error())
 	}
 
-	
-}
\ No newline at end of file
+}
@@ -0,0 +1,272 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Kinds of a bookable resource
+const (
+	ResourceCaddie = "caddie"
+	ResourceCart   = "cart"
+)
+
+// Resource is the struct that describes a caddie or a cart of a ground and when it can be booked.
+// Weekdays are the English day names it works on, and an empty list means every day.
+type Resource struct {
+	GroundID           string   `json:"groundID"`
+	ResourceID         string   `json:"resourceID"`
+	Kind               string   `json:"kind"`
+	Name               string   `json:"name"`
+	Weekdays           []string `json:"weekdays,omitempty" metadata:"weekdays,optional"`
+	AvailableTimeStart uint     `json:"availableTimeStart"`
+	AvailableTimeEnd   uint     `json:"availableTimeEnd"`
+	Active             bool     `json:"active"`
+	SchemaVersion      uint     `json:"schemaVersion"`
+}
+
+// SetResource is the invoke function that registers or updates a caddie or a cart of the ground.
+// Only the owner of the ground may set its resources.
+// params - groundID, JSON of the Resource
+func (s *SmartContract) SetResource(ctx contractapi.TransactionContextInterface, groundID string, resource string) error {
+	fmt.Println("SetResource called")
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireGroundOwner(ctx, ground)
+	if err != nil {
+		return err
+	}
+
+	groundResource := new(Resource)
+	err = json.Unmarshal([]byte(resource), groundResource)
+	if err != nil {
+		return newBookingError(ErrCodeInvalidRequest, "resource is not valid JSON. %s", err.Error())
+	}
+	groundResource.GroundID = groundID
+
+	if groundResource.ResourceID == "" {
+		return newBookingError(ErrCodeInvalidRequest, "resourceID is missing")
+	}
+	if groundResource.Kind != ResourceCaddie && groundResource.Kind != ResourceCart {
+		return newBookingError(ErrCodeInvalidRequest, "kind must be %s or %s", ResourceCaddie, ResourceCart)
+	}
+	if groundResource.AvailableTimeStart >= groundResource.AvailableTimeEnd || groundResource.AvailableTimeEnd > 24 {
+		return newBookingError(ErrCodeInvalidRequest, "available time %d-%d is not valid", groundResource.AvailableTimeStart, groundResource.AvailableTimeEnd)
+	}
+	for _, weekday := range groundResource.Weekdays {
+		if _, ok := parseWeekday(weekday); !ok {
+			return newBookingError(ErrCodeInvalidRequest, "%s is not a weekday", weekday)
+		}
+	}
+
+	return putResource(ctx, groundResource)
+}
+
+// QueryGroundResources returns all caddies and carts of the ground
+// params - groundID
+// returns the array of Resource
+func (s *SmartContract) QueryGroundResources(ctx contractapi.TransactionContextInterface, groundID string) ([]*Resource, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("resource", []string{groundID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var resources []*Resource
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		var resource Resource
+
+		err = json.Unmarshal(queryResponse.Value, &resource)
+		if err != nil {
+			return nil, fmt.Errorf("resource Unmarshal Error: %s", err.Error())
+		}
+
+		resources = append(resources, &resource)
+	}
+
+	return resources, nil
+}
+
+// AssignResources is the invoke function that adds caddies and carts to a booked reservation.
+// Either every resource is assigned or none is.
+// params - reservation number, owner's userID, JSON array of resourceIDs
+func (s *SmartContract) AssignResources(ctx contractapi.TransactionContextInterface, reservationNumber string, userID string, resourceIDs string) error {
+	fmt.Println("AssignResources called")
+
+	var resources []string
+	err := json.Unmarshal([]byte(resourceIDs), &resources)
+	if err != nil {
+		return newBookingError(ErrCodeInvalidRequest, "resourceIDs must be a JSON array. %s", err.Error())
+	}
+
+	reservation, err := getReservation(ctx, reservationNumber)
+	if err != nil {
+		return err
+	}
+	if reservation == nil {
+		return newBookingError(ErrCodeReservationNotFound, "%s does not exist", reservationNumber)
+	}
+
+	if reservation.UserID != userID {
+		return newBookingError(ErrCodeNotOwner, "%s does not hold %s", userID, reservationNumber)
+	}
+	if reservation.currentStatus() != StatusBooked {
+		return newBookingError(ErrCodeInvalidStatus, "%s is %s", reservationNumber, reservation.currentStatus())
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if !txTime.Before(reservation.Begin) {
+		return newBookingError(ErrCodeAlreadyStarted, "%s has already started", reservationNumber)
+	}
+
+	for _, resourceID := range reservation.Resources {
+		for _, requested := range resources {
+			if requested == resourceID {
+				return newBookingError(ErrCodeInvalidRequest, "%s is already assigned to %s", resourceID, reservationNumber)
+			}
+		}
+	}
+
+	err = validateResources(ctx, reservation.GroundID, resources, reservation.Begin, reservation.End, "")
+	if err != nil {
+		return err
+	}
+
+	for _, resourceID := range resources {
+		err = claimSlots(ctx, resourceSlotKey, []string{reservation.GroundID, resourceID}, reservation.ReservationNumber, reservation.Begin, reservation.End)
+		if err != nil {
+			return err
+		}
+	}
+
+	reservation.Resources = append(reservation.Resources, resources...)
+
+	return putReservation(ctx, reservation)
+}
+
+// validateResources is the function that checks the resources can serve the given time.
+// A resource is rejected when it is inactive, off duty, or its slots are held by another reservation,
+// the same way validateReservation rejects a taken tee time. The slots of ownHolder are taken over by the caller.
+// params - groundID, resourceIDs, begin and end time, holder of the slots taken over or ""
+func validateResources(ctx contractapi.TransactionContextInterface, groundID string, resourceIDs []string, beginTime, endTime time.Time, ownHolder string) error {
+	if len(resourceIDs) == 0 {
+		return nil
+	}
+
+	requested := make(map[string]bool)
+	for _, resourceID := range resourceIDs {
+		if requested[resourceID] {
+			return newBookingError(ErrCodeInvalidRequest, "%s appears more than once", resourceID)
+		}
+		requested[resourceID] = true
+
+		resource, err := getResource(ctx, groundID, resourceID)
+		if err != nil {
+			return err
+		}
+		if resource == nil {
+			return newBookingError(ErrCodeResourceNotFound, "%s does not exist at %s", resourceID, groundID)
+		}
+		if !resource.availableAt(beginTime, endTime) {
+			return newBookingError(ErrCodeResourceUnavailable, "%s is not available at that time", resourceID)
+		}
+
+		holder, err := slotHolder(ctx, resourceSlotKey, []string{groundID, resourceID}, beginTime, endTime, ownHolder)
+		if err != nil {
+			return err
+		}
+		if holder != "" {
+			return newBookingError(ErrCodeResourceUnavailable, "%s is already booked by %s", resourceID, holder)
+		}
+	}
+
+	return nil
+}
+
+// availableAt reports whether the resource works for the whole given time
+func (resource *Resource) availableAt(beginTime, endTime time.Time) bool {
+	if !resource.Active {
+		return false
+	}
+
+	dayBegin := time.Date(beginTime.Year(), beginTime.Month(), beginTime.Day(), 0, 0, 0, 0, beginTime.Location())
+	shiftBegin := dayBegin.Add(time.Duration(resource.AvailableTimeStart) * time.Hour)
+	shiftEnd := dayBegin.Add(time.Duration(resource.AvailableTimeEnd) * time.Hour)
+	if beginTime.Before(shiftBegin) || endTime.After(shiftEnd) {
+		return false
+	}
+
+	if len(resource.Weekdays) == 0 {
+		return true
+	}
+
+	for _, weekday := range resource.Weekdays {
+		if day, _ := parseWeekday(weekday); day == beginTime.Weekday() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getResource reads the resource of the ground
+// returns nil without an error when it does not exist
+func getResource(ctx contractapi.TransactionContextInterface, groundID string, resourceID string) (*Resource, error) {
+	resourceCompositeKey, _ := ctx.GetStub().CreateCompositeKey("resource", []string{groundID, resourceID})
+	resourceAsBytes, err := ctx.GetStub().GetState(resourceCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if resourceAsBytes == nil {
+		return nil, nil
+	}
+
+	resource := new(Resource)
+	err = json.Unmarshal(resourceAsBytes, resource)
+	if err != nil {
+		return nil, fmt.Errorf("resource Unmarshal Error: %s", err.Error())
+	}
+
+	return resource, nil
+}
+
+// putResource writes the resource to the world state
+func putResource(ctx contractapi.TransactionContextInterface, resource *Resource) error {
+	resource.SchemaVersion = schemaVersions["resource"]
+
+	resourceCompositeKey, _ := ctx.GetStub().CreateCompositeKey("resource", []string{resource.GroundID, resource.ResourceID})
+
+	resourceAsBytes, err := json.Marshal(resource)
+	if err != nil {
+		return fmt.Errorf("resource Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(resourceCompositeKey, resourceAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, resource.GroundID, resourceCompositeKey)
+}
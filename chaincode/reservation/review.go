@@ -0,0 +1,338 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxRating is the best score of every review category; 1 is the worst
+const maxRating = 5
+
+// Review is the struct that describes a golfer's review of a played round
+type Review struct {
+	GroundID          string    `json:"groundID"`
+	ReservationNumber string    `json:"reservationNumber"`
+	UserID            string    `json:"userID"`
+	CourseCondition   uint      `json:"courseCondition"`
+	Pace              uint      `json:"pace"`
+	Service           uint      `json:"service"`
+	Comment           string    `json:"comment"`
+	CreatedAt         time.Time `json:"createdAt"`
+	Reply             string    `json:"reply,omitempty" metadata:"reply,optional"`
+	RepliedAt         time.Time `json:"repliedAt"`
+	SchemaVersion     uint      `json:"schemaVersion"`
+}
+
+// ReviewSummary is the struct that keeps the aggregate ratings of a ground
+type ReviewSummary struct {
+	GroundID             string  `json:"groundID"`
+	ReviewCount          uint    `json:"reviewCount"`
+	CourseConditionTotal uint    `json:"courseConditionTotal"`
+	PaceTotal            uint    `json:"paceTotal"`
+	ServiceTotal         uint    `json:"serviceTotal"`
+	CourseCondition      float64 `json:"courseCondition"`
+	Pace                 float64 `json:"pace"`
+	Service              float64 `json:"service"`
+	Overall              float64 `json:"overall"`
+	SchemaVersion        uint    `json:"schemaVersion"`
+}
+
+// GroundReviews is the struct that returns the summary and a page of reviews of a ground
+type GroundReviews struct {
+	Summary  *ReviewSummary `json:"summary"`
+	Reviews  []*Review      `json:"reviews"`
+	Bookmark string         `json:"bookmark"`
+}
+
+// CompleteReservation is the invoke function that marks a played round as completed.
+// Only the owner of the ground may complete its rounds.
+// params - reservation number
+func (s *SmartContract) CompleteReservation(ctx contractapi.TransactionContextInterface, reservationNumber string) error {
+	fmt.Println("CompleteReservation called")
+
+	reservation, err := getReservation(ctx, reservationNumber)
+	if err != nil {
+		return err
+	}
+	if reservation == nil {
+		return newBookingError(ErrCodeReservationNotFound, "%s does not exist", reservationNumber)
+	}
+
+	ground, err := s.QueryGround(ctx, reservation.GroundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireGroundOwner(ctx, ground)
+	if err != nil {
+		return err
+	}
+
+	if reservation.currentStatus() != StatusBooked {
+		return newBookingError(ErrCodeInvalidStatus, "%s is %s", reservationNumber, reservation.currentStatus())
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if txTime.Before(reservation.Begin) {
+		return newBookingError(ErrCodeInvalidStatus, "%s has not started yet", reservationNumber)
+	}
+
+	reservation.Status = StatusCompleted
+	err = appendHistory(ctx, reservation, HistoryCompleted, "", reservation.UserID)
+	if err != nil {
+		return err
+	}
+
+	return putReservation(ctx, reservation)
+}
+
+// SubmitReview is the invoke function that reviews the ground of a completed reservation.
+// Only the golfer holding the reservation, identified by the userID attribute of the certificate, may review it, and only once.
+// params - reservation number, userID, ratings(1 to 5) of course condition, pace and service, comment
+func (s *SmartContract) SubmitReview(ctx contractapi.TransactionContextInterface, reservationNumber string, userID string, courseCondition uint, pace uint, service uint, comment string) error {
+	fmt.Println("SubmitReview called")
+
+	for _, rating := range []uint{courseCondition, pace, service} {
+		if rating < 1 || rating > maxRating {
+			return newBookingError(ErrCodeInvalidRating, "ratings must be from 1 to %d", maxRating)
+		}
+	}
+
+	reservation, err := getReservation(ctx, reservationNumber)
+	if err != nil {
+		return err
+	}
+	if reservation == nil {
+		return newBookingError(ErrCodeReservationNotFound, "%s does not exist", reservationNumber)
+	}
+
+	if reservation.UserID != userID {
+		return newBookingError(ErrCodeNotOwner, "%s does not hold %s", userID, reservationNumber)
+	}
+
+	err = requireUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if reservation.currentStatus() != StatusCompleted {
+		return newBookingError(ErrCodeNotCompleted, "%s is not completed", reservationNumber)
+	}
+
+	review, err := getReview(ctx, reservation.GroundID, reservationNumber)
+	if err != nil {
+		return err
+	}
+	if review != nil {
+		return newBookingError(ErrCodeAlreadyReviewed, "%s is already reviewed", reservationNumber)
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	review = &Review{
+		GroundID:          reservation.GroundID,
+		ReservationNumber: reservationNumber,
+		UserID:            userID,
+		CourseCondition:   courseCondition,
+		Pace:              pace,
+		Service:           service,
+		Comment:           comment,
+		CreatedAt:         txTime,
+	}
+
+	err = putReview(ctx, review)
+	if err != nil {
+		return err
+	}
+
+	// update the aggregate ratings of the ground
+	summary, err := getReviewSummary(ctx, reservation.GroundID)
+	if err != nil {
+		return err
+	}
+
+	summary.ReviewCount++
+	summary.CourseConditionTotal += courseCondition
+	summary.PaceTotal += pace
+	summary.ServiceTotal += service
+	summary.CourseCondition = float64(summary.CourseConditionTotal) / float64(summary.ReviewCount)
+	summary.Pace = float64(summary.PaceTotal) / float64(summary.ReviewCount)
+	summary.Service = float64(summary.ServiceTotal) / float64(summary.ReviewCount)
+	summary.Overall = (summary.CourseCondition + summary.Pace + summary.Service) / 3
+
+	return putReviewSummary(ctx, summary)
+}
+
+// ReplyToReview is the invoke function that posts the public reply of the ground operator to a review.
+// Only the owner of the ground may reply.
+// params - groundID, reservation number of the review, reply
+func (s *SmartContract) ReplyToReview(ctx contractapi.TransactionContextInterface, groundID string, reservationNumber string, reply string) error {
+	fmt.Println("ReplyToReview called")
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireGroundOwner(ctx, ground)
+	if err != nil {
+		return err
+	}
+
+	review, err := getReview(ctx, groundID, reservationNumber)
+	if err != nil {
+		return err
+	}
+	if review == nil {
+		return newBookingError(ErrCodeReviewNotFound, "review of %s does not exist", reservationNumber)
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	review.Reply = reply
+	review.RepliedAt = txTime
+
+	return putReview(ctx, review)
+}
+
+// QueryGroundReviews is the query function that returns the aggregate ratings and a page of reviews of the ground
+// params - groundID, page size, bookmark of the previous page
+// returns the GroundReviews
+func (s *SmartContract) QueryGroundReviews(ctx contractapi.TransactionContextInterface, groundID string, pageSize int32, bookmark string) (*GroundReviews, error) {
+	if pageSize <= 0 {
+		return nil, newBookingError(ErrCodeInvalidRequest, "pageSize must be greater than 0")
+	}
+
+	summary, err := getReviewSummary(ctx, groundID)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination("review", []string{groundID}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	groundReviews := &GroundReviews{
+		Summary:  summary,
+		Reviews:  []*Review{},
+		Bookmark: responseMetadata.Bookmark,
+	}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		var review Review
+
+		err = json.Unmarshal(queryResponse.Value, &review)
+		if err != nil {
+			return nil, fmt.Errorf("review Unmarshal Error: %s", err.Error())
+		}
+
+		groundReviews.Reviews = append(groundReviews.Reviews, &review)
+	}
+
+	return groundReviews, nil
+}
+
+// getReview reads the review of the reservation
+// returns nil without an error when it does not exist
+func getReview(ctx contractapi.TransactionContextInterface, groundID string, reservationNumber string) (*Review, error) {
+	reviewCompositeKey, _ := ctx.GetStub().CreateCompositeKey("review", []string{groundID, reservationNumber})
+	reviewAsBytes, err := ctx.GetStub().GetState(reviewCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if reviewAsBytes == nil {
+		return nil, nil
+	}
+
+	review := new(Review)
+	err = json.Unmarshal(reviewAsBytes, review)
+	if err != nil {
+		return nil, fmt.Errorf("review Unmarshal Error: %s", err.Error())
+	}
+
+	return review, nil
+}
+
+// putReview writes the review to the world state, endorsed by the owner of the ground
+func putReview(ctx contractapi.TransactionContextInterface, review *Review) error {
+	review.SchemaVersion = schemaVersions["review"]
+
+	reviewCompositeKey, _ := ctx.GetStub().CreateCompositeKey("review", []string{review.GroundID, review.ReservationNumber})
+
+	reviewAsBytes, err := json.Marshal(review)
+	if err != nil {
+		return fmt.Errorf("review Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(reviewCompositeKey, reviewAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, review.GroundID, reviewCompositeKey)
+}
+
+// getReviewSummary reads the aggregate ratings of the ground
+// returns an empty summary when the ground has no review yet
+func getReviewSummary(ctx contractapi.TransactionContextInterface, groundID string) (*ReviewSummary, error) {
+	summaryCompositeKey, _ := ctx.GetStub().CreateCompositeKey("reviewSummary", []string{groundID})
+	summaryAsBytes, err := ctx.GetStub().GetState(summaryCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	summary := &ReviewSummary{GroundID: groundID}
+	if summaryAsBytes == nil {
+		return summary, nil
+	}
+
+	err = json.Unmarshal(summaryAsBytes, summary)
+	if err != nil {
+		return nil, fmt.Errorf("reviewSummary Unmarshal Error: %s", err.Error())
+	}
+
+	return summary, nil
+}
+
+// putReviewSummary writes the aggregate ratings of the ground to the world state
+func putReviewSummary(ctx contractapi.TransactionContextInterface, summary *ReviewSummary) error {
+	summary.SchemaVersion = schemaVersions["reviewSummary"]
+
+	summaryCompositeKey, _ := ctx.GetStub().CreateCompositeKey("reviewSummary", []string{summary.GroundID})
+	summaryAsBytes, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("reviewSummary Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(summaryCompositeKey, summaryAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, summary.GroundID, summaryCompositeKey)
+}
@@ -0,0 +1,540 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// schemaVersions is the current schema version of every stored record, by the object type of its key.
+// A record written before the versions existed has no schemaVersion and reads as version 0.
+// Raise the version of a type when its struct changes, and teach its upgrade function the old layout.
+var schemaVersions = map[string]uint{
+	"ground":                2,
+	"reservation":           3,
+	"bookingPolicy":         1,
+	"membership":            1,
+	"blockBooking":          1,
+	"review":                1,
+	"reviewSummary":         1,
+	"course":                1,
+	"rateTable":             1,
+	"resource":              1,
+	"pricingPolicy":         1,
+	"coupon":                1,
+	"couponRedemption":      1,
+	"slotHold":              1,
+	"feeShare":              1,
+	"archivedReservation":   1,
+	"pairingRequest":        1,
+	"waitlist":              1,
+	"groundClosure":         1,
+	"confirmationPublicKey": 1,
+}
+
+// OutdatedRecords is the struct that returns a page of the keys of records older than the current schema.
+// Pass Bookmark to the next QueryOutdatedRecords call to read the following page; it is empty after the last page.
+type OutdatedRecords struct {
+	RecordType string   `json:"recordType"`
+	Keys       []string `json:"keys"`
+	Bookmark   string   `json:"bookmark"`
+}
+
+// couponRecordTypes are the record types kept under a coupon code rather than a groundID
+var couponRecordTypes = map[string]bool{
+	"coupon":           true,
+	"couponRedemption": true,
+}
+
+// MigrationReport is the struct that reports the progress of MigrateRecords over one batch.
+// Pass Bookmark to the next MigrateRecords call to migrate the following batch; Done is set after the last record.
+type MigrationReport struct {
+	RecordType string   `json:"recordType"`
+	Scanned    uint     `json:"scanned"`
+	Migrated   uint     `json:"migrated"`
+	Failed     uint     `json:"failed"`
+	Errors     []string `json:"errors,omitempty" metadata:"errors,optional"`
+	Bookmark   string   `json:"bookmark"`
+	Done       bool     `json:"done"`
+}
+
+// QueryOutdatedRecords is the query function that lists the records of a type that MigrateRecords still has to rewrite.
+// A page may hold fewer keys than pageSize, as the records already current are left out.
+// params - record type (the object type of its key, e.g. "reservation"), page size, bookmark of the previous page
+// returns the OutdatedRecords
+func (s *SmartContract) QueryOutdatedRecords(ctx contractapi.TransactionContextInterface, recordType string, pageSize int32, bookmark string) (*OutdatedRecords, error) {
+	currentVersion, ok := schemaVersions[recordType]
+	if !ok {
+		return nil, newBookingError(ErrCodeInvalidRequest, "%s is not a record type", recordType)
+	}
+
+	if pageSize <= 0 {
+		return nil, newBookingError(ErrCodeInvalidRequest, "pageSize must be greater than 0")
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(recordType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	outdated := &OutdatedRecords{
+		RecordType: recordType,
+		Keys:       []string{},
+		Bookmark:   responseMetadata.Bookmark,
+	}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		// a record that cannot be read is listed, so MigrateRecords reports it
+		version, err := recordVersion(queryResponse.Value)
+		if err == nil && version >= currentVersion {
+			continue
+		}
+
+		outdated.Keys = append(outdated.Keys, queryResponse.Key)
+	}
+
+	return outdated, nil
+}
+
+// MigrateRecords is the admin invoke function that rewrites a batch of up to pageSize records of a type in the current schema.
+// Call it again with the returned bookmark until the report is done. Records already current are left alone, and the
+// records of a ground or a coupon owned by another organization fail, as do old reservations whose slots another booking holds.
+// The paginated query API is read-only, so the batch is cut from plain key scans, resuming after the bookmark, the last key read.
+// The records kept under a groundID are read ground by ground, starting in the ground of the bookmark; coupons are few
+// and read in one scan.
+// params - record type (the object type of its key, e.g. "reservation"), page size, bookmark of the previous batch
+// returns the MigrationReport
+func (s *SmartContract) MigrateRecords(ctx contractapi.TransactionContextInterface, recordType string, pageSize int32, bookmark string) (*MigrationReport, error) {
+	fmt.Println("MigrateRecords called")
+
+	currentVersion, ok := schemaVersions[recordType]
+	if !ok {
+		return nil, newBookingError(ErrCodeInvalidRequest, "%s is not a record type", recordType)
+	}
+
+	if pageSize <= 0 {
+		return nil, newBookingError(ErrCodeInvalidRequest, "pageSize must be greater than 0")
+	}
+
+	// the bookmark is a key of the type, which starts with the composite key of the type alone
+	typePrefix, err := ctx.GetStub().CreateCompositeKey(recordType, []string{})
+	if err != nil {
+		return nil, err
+	}
+
+	if bookmark != "" && (!strings.HasPrefix(bookmark, typePrefix) || len(bookmark) == len(typePrefix)) {
+		return nil, newBookingError(ErrCodeInvalidRequest, "%q is not the bookmark of a %s", bookmark, recordType)
+	}
+
+	report := &MigrationReport{
+		RecordType: recordType,
+	}
+	claimed := make(map[string]string)
+
+	if couponRecordTypes[recordType] {
+		full, err := migrateBatch(ctx, recordType, []string{}, pageSize, bookmark, currentVersion, nil, report, claimed)
+		if err != nil {
+			return nil, err
+		}
+		if full {
+			return report, nil
+		}
+
+		report.Bookmark = ""
+		report.Done = true
+
+		return report, nil
+	}
+
+	bookmarkGroundID := ""
+	if bookmark != "" {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(bookmark)
+		if err != nil {
+			return nil, newBookingError(ErrCodeInvalidRequest, "%q is not the bookmark of a %s", bookmark, recordType)
+		}
+		bookmarkGroundID = attributes[0]
+	}
+
+	grounds, err := s.QueryAllGround(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ground := range grounds {
+		if ground.GroundID < bookmarkGroundID {
+			continue
+		}
+
+		// only the owner of the ground may rewrite its records
+		ownerErr := requireGroundOwner(ctx, ground)
+
+		full, err := migrateBatch(ctx, recordType, []string{ground.GroundID}, pageSize, bookmark, currentVersion, ownerErr, report, claimed)
+		if err != nil {
+			return nil, err
+		}
+		if full {
+			return report, nil
+		}
+	}
+
+	report.Bookmark = ""
+	report.Done = true
+
+	return report, nil
+}
+
+// migrateBatch migrates the records of the type under the partial key into the report, skipping those up to the bookmark.
+// A record that needs migrating fails with ownerErr when it is set.
+// returns whether the batch filled up before the end of the records
+func migrateBatch(ctx contractapi.TransactionContextInterface, recordType string, attributes []string, pageSize int32, bookmark string, currentVersion uint, ownerErr error, report *MigrationReport, claimed map[string]string) (bool, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(recordType, attributes)
+	if err != nil {
+		return false, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return false, err
+		}
+
+		if queryResponse.Key <= bookmark {
+			continue
+		}
+
+		if report.Scanned == uint(pageSize) {
+			return true, nil
+		}
+
+		report.Scanned++
+		report.Bookmark = queryResponse.Key
+
+		version, err := recordVersion(queryResponse.Value)
+		if err == nil && version >= currentVersion {
+			continue
+		}
+		if err == nil {
+			err = ownerErr
+		}
+		if err == nil {
+			err = migrateRecord(ctx, recordType, queryResponse.Value, claimed)
+		}
+
+		if err != nil {
+			report.Failed++
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", queryResponse.Key, err.Error()))
+			continue
+		}
+
+		report.Migrated++
+	}
+
+	return false, nil
+}
+
+// migrateRecord decodes an old record, upgrades it to the current schema and writes it back with the writer of its type,
+// which also sets the endorsement policy of the owner of its ground or coupon.
+// claimed carries the slots claimed by the reservations migrated before in the same transaction.
+func migrateRecord(ctx contractapi.TransactionContextInterface, recordType string, value []byte, claimed map[string]string) error {
+	switch recordType {
+	case "ground":
+		ground, err := unmarshalGround(value)
+		if err != nil {
+			return err
+		}
+
+		return putGround(ctx, ground)
+
+	case "reservation":
+		reservation, err := unmarshalReservation(value)
+		if err != nil {
+			return err
+		}
+
+		// reservations made before the slot keys do not hold their slots yet,
+		// and the first release let them overlap, so a slot held by another booking fails the migration
+		if reservation.currentStatus() != StatusCancelled {
+			keys, err := reservationSlotKeys(ctx, reservation)
+			if err != nil {
+				return err
+			}
+
+			err = claimLegacySlots(ctx, reservation.GroundID, reservation.ReservationNumber, keys, claimed)
+			if err != nil {
+				return err
+			}
+		}
+
+		// putReservation also writes the indexes the older reservations lack
+		return putReservation(ctx, reservation)
+
+	case "bookingPolicy":
+		bookingPolicy := new(BookingPolicy)
+		err := json.Unmarshal(value, bookingPolicy)
+		if err != nil {
+			return fmt.Errorf("bookingPolicy Unmarshal Error: %s", err.Error())
+		}
+
+		// version 0: empty lists and windows were left out of the record
+		bookingPolicy.fillEmpty()
+
+		return putBookingPolicy(ctx, bookingPolicy)
+
+	case "membership":
+		membership := new(Membership)
+		err := json.Unmarshal(value, membership)
+		if err != nil {
+			return fmt.Errorf("membership Unmarshal Error: %s", err.Error())
+		}
+
+		return putMembership(ctx, membership)
+
+	case "blockBooking":
+		blockBooking := new(BlockBooking)
+		err := json.Unmarshal(value, blockBooking)
+		if err != nil {
+			return fmt.Errorf("blockBooking Unmarshal Error: %s", err.Error())
+		}
+
+		// version 0: a block without groups was written with null groups
+		if blockBooking.Groups == nil {
+			blockBooking.Groups = []*BlockGroup{}
+		}
+
+		return putBlockBooking(ctx, blockBooking)
+
+	case "review":
+		review := new(Review)
+		err := json.Unmarshal(value, review)
+		if err != nil {
+			return fmt.Errorf("review Unmarshal Error: %s", err.Error())
+		}
+
+		return putReview(ctx, review)
+
+	case "reviewSummary":
+		summary := new(ReviewSummary)
+		err := json.Unmarshal(value, summary)
+		if err != nil {
+			return fmt.Errorf("reviewSummary Unmarshal Error: %s", err.Error())
+		}
+
+		return putReviewSummary(ctx, summary)
+
+	case "course":
+		course := new(Course)
+		err := json.Unmarshal(value, course)
+		if err != nil {
+			return fmt.Errorf("course Unmarshal Error: %s", err.Error())
+		}
+
+		return putCourse(ctx, course)
+
+	case "rateTable":
+		rateTable := new(RateTable)
+		err := json.Unmarshal(value, rateTable)
+		if err != nil {
+			return fmt.Errorf("rateTable Unmarshal Error: %s", err.Error())
+		}
+
+		return putRateTable(ctx, rateTable)
+
+	case "resource":
+		resource := new(Resource)
+		err := json.Unmarshal(value, resource)
+		if err != nil {
+			return fmt.Errorf("resource Unmarshal Error: %s", err.Error())
+		}
+
+		return putResource(ctx, resource)
+
+	case "pricingPolicy":
+		pricingPolicy := new(PricingPolicy)
+		err := json.Unmarshal(value, pricingPolicy)
+		if err != nil {
+			return fmt.Errorf("pricingPolicy Unmarshal Error: %s", err.Error())
+		}
+
+		return putPricingPolicy(ctx, pricingPolicy)
+
+	case "coupon":
+		coupon := new(Coupon)
+		err := json.Unmarshal(value, coupon)
+		if err != nil {
+			return fmt.Errorf("coupon Unmarshal Error: %s", err.Error())
+		}
+
+		// only the owner of the coupon may rewrite it
+		err = requireCouponOwner(ctx, coupon)
+		if err != nil {
+			return err
+		}
+
+		return putCoupon(ctx, coupon)
+
+	case "couponRedemption":
+		redemption := new(CouponRedemption)
+		err := json.Unmarshal(value, redemption)
+		if err != nil {
+			return fmt.Errorf("couponRedemption Unmarshal Error: %s", err.Error())
+		}
+
+		coupon, err := getCoupon(ctx, redemption.Code)
+		if err != nil {
+			return err
+		}
+		if coupon == nil {
+			return newBookingError(ErrCodeCouponNotFound, "coupon %s does not exist", redemption.Code)
+		}
+
+		err = requireCouponOwner(ctx, coupon)
+		if err != nil {
+			return err
+		}
+
+		return putCouponRedemption(ctx, coupon, redemption)
+
+	case "confirmationPublicKey":
+		publicKey := new(ConfirmationPublicKey)
+		err := json.Unmarshal(value, publicKey)
+		if err != nil {
+			return fmt.Errorf("confirmationPublicKey Unmarshal Error: %s", err.Error())
+		}
+
+		return putConfirmationPublicKey(ctx, publicKey)
+
+	case "slotHold":
+		hold := new(SlotHold)
+		err := json.Unmarshal(value, hold)
+		if err != nil {
+			return fmt.Errorf("slotHold Unmarshal Error: %s", err.Error())
+		}
+
+		return putSlotHold(ctx, hold)
+
+	case "feeShare":
+		share := new(FeeShare)
+		err := json.Unmarshal(value, share)
+		if err != nil {
+			return fmt.Errorf("feeShare Unmarshal Error: %s", err.Error())
+		}
+
+		return putShare(ctx, share)
+
+	case "archivedReservation":
+		archived := new(ArchivedReservation)
+		err := json.Unmarshal(value, archived)
+		if err != nil {
+			return fmt.Errorf("archivedReservation Unmarshal Error: %s", err.Error())
+		}
+
+		return putArchivedReservation(ctx, archived)
+
+	case "pairingRequest":
+		request := new(PairingRequest)
+		err := json.Unmarshal(value, request)
+		if err != nil {
+			return fmt.Errorf("pairingRequest Unmarshal Error: %s", err.Error())
+		}
+
+		return putPairingRequest(ctx, request)
+
+	case "waitlist":
+		entry := new(WaitlistEntry)
+		err := json.Unmarshal(value, entry)
+		if err != nil {
+			return fmt.Errorf("waitlist Unmarshal Error: %s", err.Error())
+		}
+
+		return putWaitlistEntry(ctx, entry)
+
+	case "groundClosure":
+		closure := new(GroundClosure)
+		err := json.Unmarshal(value, closure)
+		if err != nil {
+			return fmt.Errorf("groundClosure Unmarshal Error: %s", err.Error())
+		}
+
+		return putGroundClosure(ctx, closure)
+	}
+
+	return newBookingError(ErrCodeInvalidRequest, "%s has no upgrade", recordType)
+}
+
+// recordVersion reads the schema version of a stored record
+func recordVersion(value []byte) (uint, error) {
+	var record struct {
+		SchemaVersion uint `json:"schemaVersion"`
+	}
+
+	err := json.Unmarshal(value, &record)
+	if err != nil {
+		return 0, fmt.Errorf("record Unmarshal Error: %s", err.Error())
+	}
+
+	return record.SchemaVersion, nil
+}
+
+// unmarshalGround decodes a stored ground and upgrades it to the current schema
+func unmarshalGround(groundAsBytes []byte) (*Ground, error) {
+	ground := new(Ground)
+	err := json.Unmarshal(groundAsBytes, ground)
+	if err != nil {
+		return nil, fmt.Errorf("ground Unmarshal Error: %s", err.Error())
+	}
+
+	// version 0: grounds of the first release have no document type for the CouchDB selectors
+	if ground.SchemaVersion < 1 {
+		ground.DocType = groundDocType
+	}
+	// version 1: grounds have no owner, and stay under the chaincode endorsement policy until transferred
+
+	ground.SchemaVersion = schemaVersions["ground"]
+
+	return ground, nil
+}
+
+// unmarshalReservation decodes a stored reservation and upgrades it to the current schema
+func unmarshalReservation(reservationAsBytes []byte) (*Reservation, error) {
+	reservation := new(Reservation)
+	err := json.Unmarshal(reservationAsBytes, reservation)
+	if err != nil {
+		return nil, fmt.Errorf("reservation Unmarshal Error: %s", err.Error())
+	}
+
+	// version 0: reservations of the first release have neither a status nor a group size
+	if reservation.SchemaVersion < 1 {
+		if reservation.Status == "" {
+			reservation.Status = StatusBooked
+		}
+		if reservation.Players == 0 {
+			reservation.Players = defaultPlayers
+		}
+	}
+
+	// version 1: reservations have no quote and are missing from the index by day until migrated
+
+	// version 2: quotes were not dynamically priced, so they were charged at the rate table price
+	if reservation.SchemaVersion < 3 && reservation.Quote != nil && reservation.Quote.BasePrice == 0 {
+		reservation.Quote.BasePrice = reservation.Quote.UnitPrice
+	}
+
+	reservation.SchemaVersion = schemaVersions["reservation"]
+
+	return reservation, nil
+}
@@ -0,0 +1,276 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// groundDocType marks ground documents for CouchDB rich queries
+const groundDocType = "ground"
+
+// richQueryUnsupported is part of the error a peer on LevelDB answers a rich query with
+const richQueryUnsupported = "not supported"
+
+// Amenities names accepted by GroundFilter
+const (
+	AmenityDrivingRange = "drivingRange"
+	AmenityLodging      = "lodging"
+	AmenityNightGolf    = "nightGolf"
+)
+
+// earthRadiusKm is used to measure the distance between coordinates
+const earthRadiusKm = 6371.0
+
+// Amenities is the struct that informs the facilities of a ground
+type Amenities struct {
+	DrivingRange bool `json:"drivingRange"`
+	Lodging      bool `json:"lodging"`
+	NightGolf    bool `json:"nightGolf"`
+}
+
+// GroundProfile is the struct that carries the discovery details of a ground
+type GroundProfile struct {
+	Region    string    `json:"region"`
+	Address   string    `json:"address"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Amenities Amenities `json:"amenities"`
+	Contact   string    `json:"contact"`
+}
+
+// GroundFilter is the struct that describes a ground search.
+// Empty fields do not filter. Name matches any part of the ground name, ignoring case.
+// Near limits the result to grounds within RadiusKm of the coordinates.
+type GroundFilter struct {
+	Name      string     `json:"name"`
+	Region    string     `json:"region"`
+	TotalHole uint       `json:"totalHole"`
+	Amenities []string   `json:"amenities"`
+	Near      *GeoFilter `json:"near"`
+}
+
+// GeoFilter is the struct that describes a search around coordinates
+type GeoFilter struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	RadiusKm  float64 `json:"radiusKm"`
+}
+
+// GroundSearchResult is the struct that returns a page of grounds.
+// Pass Bookmark to the next SearchGrounds call to read the following page; it is empty after the last page.
+type GroundSearchResult struct {
+	Grounds      []*Ground `json:"grounds"`
+	Bookmark     string    `json:"bookmark"`
+	FetchedCount int32     `json:"fetchedCount"`
+}
+
+// UpdateGroundProfile is the invoke function that sets the region, address, coordinates, amenities and contact of a ground.
+// Only the owner of the ground may update it.
+// params - groundID, JSON of the GroundProfile
+func (s *SmartContract) UpdateGroundProfile(ctx contractapi.TransactionContextInterface, groundID string, profile string) error {
+	fmt.Println("UpdateGroundProfile called")
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireGroundOwner(ctx, ground)
+	if err != nil {
+		return err
+	}
+
+	groundProfile := new(GroundProfile)
+	err = json.Unmarshal([]byte(profile), groundProfile)
+	if err != nil {
+		return newBookingError(ErrCodeInvalidRequest, "profile is not valid JSON. %s", err.Error())
+	}
+
+	if math.Abs(groundProfile.Latitude) > 90 || math.Abs(groundProfile.Longitude) > 180 {
+		return newBookingError(ErrCodeInvalidRequest, "coordinates %f, %f are out of range", groundProfile.Latitude, groundProfile.Longitude)
+	}
+
+	ground.Region = groundProfile.Region
+	ground.Address = groundProfile.Address
+	ground.Latitude = groundProfile.Latitude
+	ground.Longitude = groundProfile.Longitude
+	ground.Amenities = groundProfile.Amenities
+	ground.Contact = groundProfile.Contact
+
+	return putGround(ctx, ground)
+}
+
+// SearchGrounds is the query function that returns a page of grounds matching the filter.
+// It runs as a CouchDB rich query backed by the indexes in META-INF. A peer on LevelDB refuses the rich query,
+// and the search then falls back on a filtered range scan, where a page may hold fewer than pageSize grounds.
+// The rich query selects on docType, so grounds written before docType existed are found on CouchDB
+// only after MigrateRecords has rewritten the "ground" records.
+// params - JSON of the GroundFilter, page size, bookmark of the previous page
+// returns the GroundSearchResult
+func (s *SmartContract) SearchGrounds(ctx contractapi.TransactionContextInterface, filter string, pageSize int32, bookmark string) (*GroundSearchResult, error) {
+	groundFilter := new(GroundFilter)
+	if filter != "" {
+		err := json.Unmarshal([]byte(filter), groundFilter)
+		if err != nil {
+			return nil, newBookingError(ErrCodeInvalidRequest, "filter is not valid JSON. %s", err.Error())
+		}
+	}
+
+	for _, amenity := range groundFilter.Amenities {
+		if amenity != AmenityDrivingRange && amenity != AmenityLodging && amenity != AmenityNightGolf {
+			return nil, newBookingError(ErrCodeInvalidRequest, "%s is not an amenity", amenity)
+		}
+	}
+
+	if pageSize <= 0 {
+		return nil, newBookingError(ErrCodeInvalidRequest, "pageSize must be greater than 0")
+	}
+
+	queryString, err := groundFilter.selector()
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetQueryResultWithPagination(queryString, pageSize, bookmark)
+	if err != nil && strings.Contains(err.Error(), richQueryUnsupported) {
+		resultsIterator, responseMetadata, err = ctx.GetStub().GetStateByPartialCompositeKeyWithPagination("ground", []string{}, pageSize, bookmark)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &GroundSearchResult{
+		Grounds:      []*Ground{},
+		Bookmark:     responseMetadata.Bookmark,
+		FetchedCount: responseMetadata.FetchedRecordsCount,
+	}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		ground, err := unmarshalGround(queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		// the selector cannot measure distances, and LevelDB cannot filter at all
+		if groundFilter.matches(ground) {
+			result.Grounds = append(result.Grounds, ground)
+		}
+	}
+
+	return result, nil
+}
+
+// putGround writes the ground to the world state under its composite key,
+// endorsed by its owner
+func putGround(ctx contractapi.TransactionContextInterface, ground *Ground) error {
+	ground.DocType = groundDocType
+	ground.SchemaVersion = schemaVersions["ground"]
+
+	groundCompositeKey, _ := ctx.GetStub().CreateCompositeKey("ground", []string{ground.GroundID})
+	groundAsBytes, err := json.Marshal(ground)
+	if err != nil {
+		return fmt.Errorf("ground Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(groundCompositeKey, groundAsBytes)
+	if err != nil {
+		return err
+	}
+
+	if ground.OwnerMSP == "" {
+		return nil
+	}
+
+	policy, err := ownerEndorsementPolicy(ground.OwnerMSP)
+	if err != nil {
+		return err
+	}
+
+	return setEndorsement(ctx, policy, groundCompositeKey)
+}
+
+// selector builds the CouchDB query of the filter
+func (groundFilter *GroundFilter) selector() (string, error) {
+	selector := map[string]interface{}{
+		"docType": groundDocType,
+	}
+
+	if groundFilter.Region != "" {
+		selector["region"] = groundFilter.Region
+	}
+	if groundFilter.TotalHole != 0 {
+		selector["totalHole"] = groundFilter.TotalHole
+	}
+	if groundFilter.Name != "" {
+		selector["groundName"] = map[string]string{"$regex": "(?i)" + regexp.QuoteMeta(groundFilter.Name)}
+	}
+	for _, amenity := range groundFilter.Amenities {
+		selector["amenities."+amenity] = true
+	}
+	if near := groundFilter.Near; near != nil {
+		// bounding box of the circle, the exact distance is checked by matches
+		latDelta := near.RadiusKm / earthRadiusKm * 180 / math.Pi
+		lonDelta := latDelta / math.Max(math.Cos(near.Latitude*math.Pi/180), 0.01)
+		selector["latitude"] = map[string]float64{"$gte": near.Latitude - latDelta, "$lte": near.Latitude + latDelta}
+		selector["longitude"] = map[string]float64{"$gte": near.Longitude - lonDelta, "$lte": near.Longitude + lonDelta}
+	}
+
+	queryAsBytes, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return "", fmt.Errorf("selector Marshal Error: %s", err.Error())
+	}
+
+	return string(queryAsBytes), nil
+}
+
+// matches reports whether the ground satisfies every condition of the filter
+func (groundFilter *GroundFilter) matches(ground *Ground) bool {
+	if groundFilter.Region != "" && ground.Region != groundFilter.Region {
+		return false
+	}
+	if groundFilter.TotalHole != 0 && ground.TotalHole != groundFilter.TotalHole {
+		return false
+	}
+	if groundFilter.Name != "" && !strings.Contains(strings.ToLower(ground.GroundName), strings.ToLower(groundFilter.Name)) {
+		return false
+	}
+	for _, amenity := range groundFilter.Amenities {
+		if (amenity == AmenityDrivingRange && !ground.Amenities.DrivingRange) ||
+			(amenity == AmenityLodging && !ground.Amenities.Lodging) ||
+			(amenity == AmenityNightGolf && !ground.Amenities.NightGolf) {
+			return false
+		}
+	}
+	if near := groundFilter.Near; near != nil && distanceKm(near.Latitude, near.Longitude, ground.Latitude, ground.Longitude) > near.RadiusKm {
+		return false
+	}
+
+	return true
+}
+
+// distanceKm returns the great-circle distance between two coordinates
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
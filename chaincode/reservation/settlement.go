@@ -0,0 +1,484 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Status of a FeeShare
+const (
+	ShareUnpaid  = "unpaid"
+	ShareSettled = "settled"
+	ShareWaived  = "waived"
+	// ShareVoid is the status of the unpaid shares of a cancelled reservation
+	ShareVoid = "void"
+)
+
+// FeeShare is the struct that tracks what a participant owes the holder of a reservation for the green fee.
+// The holder pays the whole fee at booking, and every other participant owes the price of one player.
+type FeeShare struct {
+	GroundID          string    `json:"groundID"`
+	ReservationNumber string    `json:"reservationNumber"`
+	UserID            string    `json:"userID"`
+	PayeeID           string    `json:"payeeID"`
+	Begin             time.Time `json:"begin"`
+	Currency          string    `json:"currency"`
+	Amount            uint      `json:"amount"`
+	Status            string    `json:"status"`
+	SettledTxID       string    `json:"settledTxID,omitempty" metadata:"settledTxID,optional"`
+	SchemaVersion     uint      `json:"schemaVersion"`
+}
+
+// ReservationBalance is the struct that shows the shares of a reservation and what is still owed
+type ReservationBalance struct {
+	ReservationNumber string      `json:"reservationNumber"`
+	Currency          string      `json:"currency"`
+	Total             uint        `json:"total"`
+	Outstanding       uint        `json:"outstanding"`
+	Shares            []*FeeShare `json:"shares"`
+}
+
+// UserBalance is the struct that shows the unpaid shares of a golfer and their sum by currency
+type UserBalance struct {
+	UserID      string            `json:"userID"`
+	Outstanding []*CurrencyAmount `json:"outstanding"`
+	Shares      []*FeeShare       `json:"shares"`
+}
+
+// CurrencyAmount is the struct that holds an amount of a currency
+type CurrencyAmount struct {
+	Currency string `json:"currency"`
+	Amount   uint   `json:"amount"`
+}
+
+// SettleShare is the invoke function that records that a participant paid their share to the holder.
+// Only the payee of the share, who received the payment, or the owner of the ground may record it.
+// params - reservation number, userID of the participant
+func (s *SmartContract) SettleShare(ctx contractapi.TransactionContextInterface, reservationNumber string, userID string) error {
+	fmt.Println("SettleShare called")
+
+	return s.closeShare(ctx, reservationNumber, userID, "", ShareSettled)
+}
+
+// WaiveShare is the invoke function that lets the holder forgive the share of a participant
+// params - reservation number, userID of the holder, userID of the participant
+func (s *SmartContract) WaiveShare(ctx contractapi.TransactionContextInterface, reservationNumber string, payeeID string, userID string) error {
+	fmt.Println("WaiveShare called")
+
+	return s.closeShare(ctx, reservationNumber, userID, payeeID, ShareWaived)
+}
+
+// QueryReservationShares returns the shares of the reservation and what is still owed
+// params - reservation number
+// returns the ReservationBalance
+func (s *SmartContract) QueryReservationShares(ctx contractapi.TransactionContextInterface, reservationNumber string) (*ReservationBalance, error) {
+	reservation, err := getReservation(ctx, reservationNumber)
+	if err != nil {
+		return nil, err
+	}
+	if reservation == nil {
+		return nil, newBookingError(ErrCodeReservationNotFound, "%s does not exist", reservationNumber)
+	}
+
+	balance := &ReservationBalance{
+		ReservationNumber: reservationNumber,
+		Shares:            []*FeeShare{},
+	}
+	if reservation.Quote != nil {
+		balance.Currency = reservation.Quote.Currency
+		balance.Total = reservation.Quote.Total
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("feeShare", []string{reservation.GroundID, reservationNumber})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		share := new(FeeShare)
+		err = json.Unmarshal(queryResponse.Value, share)
+		if err != nil {
+			return nil, fmt.Errorf("feeShare Unmarshal Error: %s", err.Error())
+		}
+
+		if share.Status == ShareUnpaid {
+			balance.Outstanding += share.Amount
+		}
+		balance.Shares = append(balance.Shares, share)
+	}
+
+	return balance, nil
+}
+
+// QueryUserBalance returns the unpaid shares of the golfer
+// params - userID
+// returns the UserBalance
+func (s *SmartContract) QueryUserBalance(ctx contractapi.TransactionContextInterface, userID string) (*UserBalance, error) {
+	shares, err := queryUserShares(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	balance := &UserBalance{
+		UserID:      userID,
+		Outstanding: []*CurrencyAmount{},
+		Shares:      []*FeeShare{},
+	}
+
+	for _, share := range shares {
+		if share.Status != ShareUnpaid {
+			continue
+		}
+
+		balance.Shares = append(balance.Shares, share)
+
+		var sum *CurrencyAmount
+		for _, outstanding := range balance.Outstanding {
+			if outstanding.Currency == share.Currency {
+				sum = outstanding
+			}
+		}
+		if sum == nil {
+			sum = &CurrencyAmount{Currency: share.Currency}
+			balance.Outstanding = append(balance.Outstanding, sum)
+		}
+		sum.Amount += share.Amount
+	}
+
+	return balance, nil
+}
+
+// checkParticipants validates the participants of a reservation request
+// returns the participants with the holder first, or nil when none were listed
+func checkParticipants(userID string, participants []string, players uint) ([]string, error) {
+	if len(participants) == 0 {
+		return nil, nil
+	}
+
+	checked := []string{userID}
+	for _, participant := range participants {
+		if participant == "" {
+			return nil, newBookingError(ErrCodeInvalidRequest, "participant must not be empty")
+		}
+		if participant == userID {
+			continue
+		}
+
+		for _, other := range checked {
+			if other == participant {
+				return nil, newBookingError(ErrCodeInvalidRequest, "%s is listed twice", participant)
+			}
+		}
+
+		checked = append(checked, participant)
+	}
+
+	if uint(len(checked)) > players {
+		return nil, newBookingError(ErrCodeInvalidRequest, "%d participants do not fit a group of %d", len(checked), players)
+	}
+
+	return checked, nil
+}
+
+// createShares writes the share of every participant but the holder of a priced reservation
+func createShares(ctx contractapi.TransactionContextInterface, reservation *Reservation) error {
+	if reservation.Quote == nil || reservation.Quote.Players == 0 {
+		return nil
+	}
+
+	// the holder keeps the remainder of an uneven split
+	amount := reservation.Quote.Total / reservation.Quote.Players
+
+	for _, participant := range reservation.Participants {
+		if participant == reservation.UserID {
+			continue
+		}
+
+		err := putShare(ctx, &FeeShare{
+			GroundID:          reservation.GroundID,
+			ReservationNumber: reservation.ReservationNumber,
+			UserID:            participant,
+			PayeeID:           reservation.UserID,
+			Begin:             reservation.Begin,
+			Currency:          reservation.Quote.Currency,
+			Amount:            amount,
+			Status:            ShareUnpaid,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// voidShares voids the unpaid shares of a cancelled reservation
+func voidShares(ctx contractapi.TransactionContextInterface, reservation *Reservation) error {
+	for _, participant := range reservation.Participants {
+		share, err := getShare(ctx, reservation.GroundID, reservation.ReservationNumber, participant)
+		if err != nil {
+			return err
+		}
+		if share == nil || share.Status != ShareUnpaid {
+			continue
+		}
+
+		share.Status = ShareVoid
+		err = putShare(ctx, share)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// transferShares hands the shares of a reservation over to its new holder.
+// The unpaid shares are then owed to the new holder, and the share the new holder owed as a participant is void.
+func transferShares(ctx contractapi.TransactionContextInterface, reservation *Reservation, fromUserID string, toUserID string) error {
+	participants := []string{}
+
+	for _, participant := range reservation.Participants {
+		if participant == fromUserID || participant == toUserID {
+			continue
+		}
+
+		share, err := getShare(ctx, reservation.GroundID, reservation.ReservationNumber, participant)
+		if err != nil {
+			return err
+		}
+		if share != nil && share.Status == ShareUnpaid {
+			share.PayeeID = toUserID
+			err = putShare(ctx, share)
+			if err != nil {
+				return err
+			}
+		}
+
+		participants = append(participants, participant)
+	}
+
+	if len(reservation.Participants) == 0 {
+		return nil
+	}
+
+	share, err := getShare(ctx, reservation.GroundID, reservation.ReservationNumber, toUserID)
+	if err != nil {
+		return err
+	}
+	if share != nil && share.Status == ShareUnpaid {
+		share.Status = ShareVoid
+		err = putShare(ctx, share)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the holder is listed first
+	reservation.Participants = append([]string{toUserID}, participants...)
+
+	return nil
+}
+
+// closeShare marks an unpaid share settled or waived.
+// The payee of the share, or the owner of the ground, must send it, and a waiver must name the payee.
+func (s *SmartContract) closeShare(ctx contractapi.TransactionContextInterface, reservationNumber string, userID string, payeeID string, status string) error {
+	reservation, err := getReservation(ctx, reservationNumber)
+	if err != nil {
+		return err
+	}
+	if reservation == nil {
+		return newBookingError(ErrCodeReservationNotFound, "%s does not exist", reservationNumber)
+	}
+
+	share, err := getShare(ctx, reservation.GroundID, reservationNumber, userID)
+	if err != nil {
+		return err
+	}
+	if share == nil {
+		return newBookingError(ErrCodeShareNotFound, "%s has no share of %s", userID, reservationNumber)
+	}
+
+	if status == ShareWaived && share.PayeeID != payeeID {
+		return newBookingError(ErrCodeNotOwner, "%s is not owed the share of %s", payeeID, userID)
+	}
+
+	ground, err := s.QueryGround(ctx, reservation.GroundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireUserOrOwner(ctx, ground, share.PayeeID)
+	if err != nil {
+		return err
+	}
+	if share.Status != ShareUnpaid {
+		return newBookingError(ErrCodeInvalidStatus, "the share of %s is %s", userID, share.Status)
+	}
+
+	share.Status = status
+	share.SettledTxID = ctx.GetStub().GetTxID()
+	err = putShare(ctx, share)
+	if err != nil {
+		return err
+	}
+
+	events := new(eventBatch)
+	err = events.add(EventShareClosed, share)
+	if err != nil {
+		return err
+	}
+
+	return events.emit(ctx)
+}
+
+// hasOverdueShares reports whether the golfer has not paid a share of a tee time that has already started
+func hasOverdueShares(ctx contractapi.TransactionContextInterface, userID string, txTime time.Time) (bool, error) {
+	shares, err := queryUserShares(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, share := range shares {
+		if share.Status == ShareUnpaid && share.Begin.Before(txTime) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// queryUserShares returns every share of the golfer
+func queryUserShares(ctx contractapi.TransactionContextInterface, userID string) ([]*FeeShare, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("userFeeShare", []string{userID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var shares []*FeeShare
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		shareAsBytes, err := ctx.GetStub().GetState(string(queryResponse.Value))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+		}
+
+		if shareAsBytes == nil {
+			continue
+		}
+
+		share := new(FeeShare)
+		err = json.Unmarshal(shareAsBytes, share)
+		if err != nil {
+			return nil, fmt.Errorf("feeShare Unmarshal Error: %s", err.Error())
+		}
+
+		shares = append(shares, share)
+	}
+
+	return shares, nil
+}
+
+// getShare reads the share of the participant
+// returns nil without an error when the participant has none
+func getShare(ctx contractapi.TransactionContextInterface, groundID string, reservationNumber string, userID string) (*FeeShare, error) {
+	shareCompositeKey, _ := ctx.GetStub().CreateCompositeKey("feeShare", []string{groundID, reservationNumber, userID})
+	shareAsBytes, err := ctx.GetStub().GetState(shareCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if shareAsBytes == nil {
+		return nil, nil
+	}
+
+	share := new(FeeShare)
+	err = json.Unmarshal(shareAsBytes, share)
+	if err != nil {
+		return nil, fmt.Errorf("feeShare Unmarshal Error: %s", err.Error())
+	}
+
+	return share, nil
+}
+
+// putShare writes the share and its index by participant
+func putShare(ctx contractapi.TransactionContextInterface, share *FeeShare) error {
+	share.SchemaVersion = schemaVersions["feeShare"]
+
+	keys := shareKeys(ctx, share)
+	shareAsBytes, err := json.Marshal(share)
+	if err != nil {
+		return fmt.Errorf("feeShare Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(keys[0], shareAsBytes)
+	if err != nil {
+		return err
+	}
+
+	// the index holds the composite key of the share
+	err = ctx.GetStub().PutState(keys[1], []byte(keys[0]))
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, share.GroundID, keys...)
+}
+
+// shareKeys returns the key of the share and of its index by participant
+func shareKeys(ctx contractapi.TransactionContextInterface, share *FeeShare) []string {
+	shareCompositeKey, _ := ctx.GetStub().CreateCompositeKey("feeShare", []string{share.GroundID, share.ReservationNumber, share.UserID})
+	userIndexKey, _ := ctx.GetStub().CreateCompositeKey("userFeeShare", []string{share.UserID, share.ReservationNumber})
+
+	return []string{shareCompositeKey, userIndexKey}
+}
+
+// queryGroundShares returns every share of the reservations of the ground
+func queryGroundShares(ctx contractapi.TransactionContextInterface, groundID string) ([]*FeeShare, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("feeShare", []string{groundID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	shares := []*FeeShare{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		share := new(FeeShare)
+		err = json.Unmarshal(queryResponse.Value, share)
+		if err != nil {
+			return nil, fmt.Errorf("feeShare Unmarshal Error: %s", err.Error())
+		}
+
+		shares = append(shares, share)
+	}
+
+	return shares, nil
+}
@@ -0,0 +1,342 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// slotInterval is the length of a slot. A booking holds every slot its play time touches,
+// so two overlapping bookings always write at least one same key and MVCC invalidates one of them.
+// A booking ending when the next begins shares no slot with it, so back-to-back bookings are accepted,
+// which the check before the slots rejected. Plays begin on the start of a slot: a play beginning inside a slot
+// would hold the slot of the play ending there, and a play ending inside a slot blocks only its rest.
+const slotInterval = 10 * time.Minute
+
+// maxPlayTime is the longest time one booking may hold, which bounds the slot keys it writes
+const maxPlayTime = 24 * time.Hour
+
+// slotKeyFormat formats the start of a slot in UTC, so the key does not depend on the offset of a request
+const slotKeyFormat = "20060102T1504Z"
+
+// Object types of the slot keys
+const (
+	// teeSlotKey is held per ground, as a reservation takes the whole ground
+	teeSlotKey = "teeSlot"
+	// resourceSlotKey is held per caddie or cart
+	resourceSlotKey = "resourceSlot"
+)
+
+// IndexGroundSlots is the invoke function that writes the slot keys of the bookings made before the slots existed.
+// Running it again is harmless. It fails when two bookings share a slot, which one of them must give up first.
+// Only the owner of the ground may index it.
+// params - groundID
+func (s *SmartContract) IndexGroundSlots(ctx contractapi.TransactionContextInterface, groundID string) error {
+	fmt.Println("IndexGroundSlots called")
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireGroundOwner(ctx, ground)
+	if err != nil {
+		return err
+	}
+
+	reservations, err := queryGroundReservations(ctx, groundID)
+	if err != nil {
+		return err
+	}
+
+	claimed := make(map[string]string)
+
+	for _, reservation := range reservations {
+		if reservation.currentStatus() == StatusCancelled {
+			continue
+		}
+
+		keys, err := reservationSlotKeys(ctx, reservation)
+		if err != nil {
+			return err
+		}
+
+		err = claimLegacySlots(ctx, groundID, reservation.ReservationNumber, keys, claimed)
+		if err != nil {
+			return err
+		}
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("blockBooking", []string{groundID})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return err
+		}
+
+		var blockBooking BlockBooking
+
+		err = json.Unmarshal(queryResponse.Value, &blockBooking)
+		if err != nil {
+			return fmt.Errorf("blockBooking Unmarshal Error: %s", err.Error())
+		}
+
+		keys, err := slotKeys(ctx, teeSlotKey, []string{groundID}, blockBooking.Begin, blockBooking.End)
+		if err != nil {
+			return err
+		}
+
+		err = claimLegacySlots(ctx, groundID, blockHolder(blockBooking.BlockID), keys, claimed)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// holdSlots writes the slot keys of the reservation and of its caddies and carts.
+// The groups of a block booking play inside the slots of the block, so they hold only their resources.
+func holdSlots(ctx contractapi.TransactionContextInterface, reservation *Reservation) error {
+	if reservation.BlockID == "" {
+		err := claimSlots(ctx, teeSlotKey, []string{reservation.GroundID}, reservation.ReservationNumber, reservation.Begin, reservation.End)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, resourceID := range reservation.Resources {
+		err := claimSlots(ctx, resourceSlotKey, []string{reservation.GroundID, resourceID}, reservation.ReservationNumber, reservation.Begin, reservation.End)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reservationSlotKeys returns the keys of the slots holdSlots writes for the reservation
+func reservationSlotKeys(ctx contractapi.TransactionContextInterface, reservation *Reservation) ([]string, error) {
+	var keys []string
+	if reservation.BlockID == "" {
+		teeKeys, err := slotKeys(ctx, teeSlotKey, []string{reservation.GroundID}, reservation.Begin, reservation.End)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, teeKeys...)
+	}
+
+	for _, resourceID := range reservation.Resources {
+		resourceKeys, err := slotKeys(ctx, resourceSlotKey, []string{reservation.GroundID, resourceID}, reservation.Begin, reservation.End)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, resourceKeys...)
+	}
+
+	return keys, nil
+}
+
+// claimLegacySlots writes the holder to the slot keys of a booking made before the slots existed.
+// Unlike claimSlots it fails, writing nothing, when another booking holds one of the slots, as the bookings
+// of the first release may overlap. The world state does not show the writes of the transaction, so claimed
+// carries the holders of the slots claimed before in the same transaction, and receives those written here.
+func claimLegacySlots(ctx contractapi.TransactionContextInterface, groundID string, holder string, keys []string, claimed map[string]string) error {
+	for _, key := range keys {
+		currentHolder, ok := claimed[key]
+		if !ok {
+			holderAsBytes, err := ctx.GetStub().GetState(key)
+			if err != nil {
+				return fmt.Errorf("Failed to read from world state. %s", err.Error())
+			}
+			currentHolder = string(holderAsBytes)
+		}
+
+		if currentHolder != "" && currentHolder != holder {
+			return newBookingError(ErrCodeSlotTaken, "%s shares a slot with %s", holder, currentHolder)
+		}
+	}
+
+	for _, key := range keys {
+		err := ctx.GetStub().PutState(key, []byte(holder))
+		if err != nil {
+			return err
+		}
+
+		claimed[key] = holder
+	}
+
+	return endorseByOwner(ctx, groundID, keys...)
+}
+
+// freeSlots deletes the slot keys held by the reservation, so its time can be booked again
+func freeSlots(ctx contractapi.TransactionContextInterface, reservation *Reservation) error {
+	if reservation.BlockID == "" {
+		err := releaseSlots(ctx, teeSlotKey, []string{reservation.GroundID}, reservation.ReservationNumber, reservation.Begin, reservation.End)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, resourceID := range reservation.Resources {
+		err := releaseSlots(ctx, resourceSlotKey, []string{reservation.GroundID, resourceID}, reservation.ReservationNumber, reservation.Begin, reservation.End)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// blockHolder is the holder written to the slots of a block booking
+func blockHolder(blockID string) string {
+	return "block:" + blockID
+}
+
+// checkPlayTime checks that the play time begins on the start of a slot, ends after it begins
+// and is not longer than maxPlayTime
+func checkPlayTime(beginTime, endTime time.Time) error {
+	err := checkSlotStart(beginTime)
+	if err != nil {
+		return err
+	}
+	if !endTime.After(beginTime) {
+		return newBookingError(ErrCodeInvalidRequest, "end must be after begin")
+	}
+	if endTime.Sub(beginTime) > maxPlayTime {
+		return newBookingError(ErrCodeInvalidRequest, "play time must not be longer than %s", maxPlayTime)
+	}
+
+	return nil
+}
+
+// checkSlotStart checks that the time is the start of a slot
+func checkSlotStart(beginTime time.Time) error {
+	if !beginTime.Equal(beginTime.Truncate(slotInterval)) {
+		return newBookingError(ErrCodeInvalidRequest, "%s is not on a %d minute boundary", beginTime.Format(time.RFC3339), slotInterval/time.Minute)
+	}
+
+	return nil
+}
+
+// checkOpeningHours checks that the play tees off while the ground is open, in the offset of beginTime
+func checkOpeningHours(ground *Ground, beginTime time.Time) error {
+	opening, closing := openingHours(ground, beginTime)
+	if beginTime.Before(opening) || !beginTime.Before(closing) {
+		return newBookingError(ErrCodeInvalidRequest, "%s tees off from %02d:00 to %02d:00", ground.GroundID, ground.AvailableTimeStart, ground.AvailableTimeEnd)
+	}
+
+	return nil
+}
+
+// slotStarts returns the start of every slot the given time touches.
+// Plays begin on the start of a slot, except those booked before the check, whose first slot begins earlier.
+func slotStarts(beginTime, endTime time.Time) []time.Time {
+	var starts []time.Time
+	for start := beginTime.UTC().Truncate(slotInterval); start.Before(endTime); start = start.Add(slotInterval) {
+		starts = append(starts, start)
+	}
+
+	return starts
+}
+
+// slotKey creates the key of the slot starting at the given time
+func slotKey(ctx contractapi.TransactionContextInterface, objectType string, attributes []string, start time.Time) (string, error) {
+	keyAttributes := append(append([]string{}, attributes...), start.UTC().Format(slotKeyFormat))
+
+	return ctx.GetStub().CreateCompositeKey(objectType, keyAttributes)
+}
+
+// slotKeys creates the keys of every slot the given time touches
+func slotKeys(ctx contractapi.TransactionContextInterface, objectType string, attributes []string, beginTime, endTime time.Time) ([]string, error) {
+	var keys []string
+	for _, start := range slotStarts(beginTime, endTime) {
+		key, err := slotKey(ctx, objectType, attributes, start)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// slotHolder reads the slots of the given time, counting the slots of ownHolder as free
+// returns the holder of the first taken slot, or "" when every slot is free
+func slotHolder(ctx contractapi.TransactionContextInterface, objectType string, attributes []string, beginTime, endTime time.Time, ownHolder string) (string, error) {
+	for _, start := range slotStarts(beginTime, endTime) {
+		key, err := slotKey(ctx, objectType, attributes, start)
+		if err != nil {
+			return "", err
+		}
+
+		holderAsBytes, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read from world state. %s", err.Error())
+		}
+		if holderAsBytes != nil && string(holderAsBytes) != ownHolder {
+			return string(holderAsBytes), nil
+		}
+	}
+
+	return "", nil
+}
+
+// claimSlots writes the holder to every slot of the given time.
+// The first attribute of a slot key is always the groundID, whose owner endorses the slots.
+func claimSlots(ctx contractapi.TransactionContextInterface, objectType string, attributes []string, holder string, beginTime, endTime time.Time) error {
+	var keys []string
+	for _, start := range slotStarts(beginTime, endTime) {
+		key, err := slotKey(ctx, objectType, attributes, start)
+		if err != nil {
+			return err
+		}
+
+		err = ctx.GetStub().PutState(key, []byte(holder))
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return endorseByOwner(ctx, attributes[0], keys...)
+}
+
+// releaseSlots deletes the slots of the given time that are still held by the holder
+func releaseSlots(ctx contractapi.TransactionContextInterface, objectType string, attributes []string, holder string, beginTime, endTime time.Time) error {
+	for _, start := range slotStarts(beginTime, endTime) {
+		key, err := slotKey(ctx, objectType, attributes, start)
+		if err != nil {
+			return err
+		}
+
+		holderAsBytes, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return fmt.Errorf("Failed to read from world state. %s", err.Error())
+		}
+		if string(holderAsBytes) != holder {
+			continue
+		}
+
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
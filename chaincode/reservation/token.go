@@ -0,0 +1,482 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// A confirmation token is "<payload>.<signature>", both in unpadded base64url, small enough for a QR code.
+// The payload is tokenVersion, the reservation number, groundID, begin and end (slotKeyFormat),
+// game code and the ID of the booking transaction, joined by "|".
+// The signature is the ECDSA P-256 signature of the SHA-256 of the encoded payload, r and s each in 32 big-endian bytes,
+// made with the confirmation key of the ground. Its public key is published on the ledger, so gate staff can check
+// a token offline with the public key alone, and against the ledger when online.
+const tokenVersion = "2"
+
+// confirmationKeyTransient is the transient field that carries the confirmation key to SetConfirmationKey
+const confirmationKeyTransient = "confirmationKey"
+
+// tokenSignatureLength is the length of a token signature, r and s of P-256, in bytes
+const tokenSignatureLength = 64
+
+// Verdicts of VerifyConfirmationToken
+const (
+	TokenValid        = "VALID"
+	TokenMalformed    = "MALFORMED"
+	TokenBadSignature = "BAD_SIGNATURE"
+	TokenNotFound     = "RESERVATION_NOT_FOUND"
+	TokenCancelled    = "CANCELLED"
+	TokenRescheduled  = "RESCHEDULED"
+	TokenMismatch     = "MISMATCH"
+	TokenNotBooked    = "NOT_BOOKED"
+)
+
+// implicitCollectionPrefix names the implicit private data collection of an organization with its MSP ID
+const implicitCollectionPrefix = "_implicit_org_"
+
+// ConfirmationKey is the struct that holds the private key a ground signs its confirmation tokens with, in SEC 1 DER.
+// It is kept in the implicit collection of the owner of the ground, so only the peers of the owner hold it
+// and the channel sees its hash alone. Replacing the key invalidates every token issued with the old one,
+// and a new owner sets a key of its own after a transfer.
+type ConfirmationKey struct {
+	GroundID string `json:"groundID"`
+	Key      []byte `json:"key"`
+}
+
+// ConfirmationPublicKey is the struct that publishes the public half of the confirmation key of a ground,
+// a PEM "PUBLIC KEY" block, for gate staff to check tokens with. It is deleted when the ground is transferred.
+type ConfirmationPublicKey struct {
+	GroundID      string `json:"groundID"`
+	PublicKey     string `json:"publicKey"`
+	SchemaVersion uint   `json:"schemaVersion"`
+}
+
+// ConfirmationToken is the struct that returns a token and the booking it confirms
+type ConfirmationToken struct {
+	ReservationNumber string    `json:"reservationNumber"`
+	GroundID          string    `json:"groundID"`
+	Begin             time.Time `json:"begin"`
+	End               time.Time `json:"end"`
+	GameCode          int       `json:"gameCode"`
+	TxID              string    `json:"txID"`
+	Token             string    `json:"token"`
+}
+
+// TokenVerification is the struct that tells whether a token still confirms its reservation
+type TokenVerification struct {
+	ReservationNumber string `json:"reservationNumber"`
+	Valid             bool   `json:"valid"`
+	Verdict           string `json:"verdict"`
+	Status            string `json:"status"`
+}
+
+// SetConfirmationKey is the invoke function that sets the key the ground signs its confirmation tokens with
+// and publishes its public key. The key is read from the "confirmationKey" transient field, a PEM block holding
+// a P-256 private key in SEC 1 ("EC PRIVATE KEY") or PKCS #8 ("PRIVATE KEY").
+// Only the owner of the ground may set it, and a ground without an owner has no collection to keep it in.
+// params - groundID
+func (s *SmartContract) SetConfirmationKey(ctx contractapi.TransactionContextInterface, groundID string) error {
+	fmt.Println("SetConfirmationKey called")
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	if ground.OwnerMSP == "" {
+		return newBookingError(ErrCodeNotOwner, "%s has no owner to keep the confirmation key", groundID)
+	}
+
+	err = requireGroundOwner(ctx, ground)
+	if err != nil {
+		return err
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("Failed to get the transient data. %s", err.Error())
+	}
+
+	privateKey, err := parseConfirmationKey(transientMap[confirmationKeyTransient])
+	if err != nil {
+		return newBookingError(ErrCodeInvalidRequest, "the %s transient field %s", confirmationKeyTransient, err.Error())
+	}
+
+	privateKeyAsBytes, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("Failed to encode the confirmation key. %s", err.Error())
+	}
+
+	publicKeyAsBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return fmt.Errorf("Failed to encode the public key. %s", err.Error())
+	}
+
+	confirmationKey := ConfirmationKey{
+		GroundID: groundID,
+		Key:      privateKeyAsBytes,
+	}
+
+	keyCompositeKey, _ := ctx.GetStub().CreateCompositeKey("confirmationKey", []string{groundID})
+	keyAsBytes, err := json.Marshal(confirmationKey)
+	if err != nil {
+		return fmt.Errorf("confirmationKey Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutPrivateData(implicitCollectionPrefix+ground.OwnerMSP, keyCompositeKey, keyAsBytes)
+	if err != nil {
+		return err
+	}
+
+	publicKey := &ConfirmationPublicKey{
+		GroundID:  groundID,
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyAsBytes})),
+	}
+
+	return putConfirmationPublicKey(ctx, publicKey)
+}
+
+// QueryConfirmationPublicKey returns the public key that checks the confirmation tokens of the ground
+// params - groundID
+// returns the ConfirmationPublicKey
+func (s *SmartContract) QueryConfirmationPublicKey(ctx contractapi.TransactionContextInterface, groundID string) (*ConfirmationPublicKey, error) {
+	publicKey, err := getConfirmationPublicKey(ctx, groundID)
+	if err != nil {
+		return nil, err
+	}
+
+	if publicKey == nil {
+		return nil, fmt.Errorf("%s has no confirmation key", groundID)
+	}
+
+	return publicKey, nil
+}
+
+// IssueConfirmationToken is the query function that issues the signed confirmation token of a booked reservation.
+// It must be sent to a peer of the owner of the ground, which holds the confirmation key.
+// params - reservation number, userID of the holder
+// returns the ConfirmationToken
+func (s *SmartContract) IssueConfirmationToken(ctx contractapi.TransactionContextInterface, reservationNumber string, userID string) (*ConfirmationToken, error) {
+	reservation, err := getReservation(ctx, reservationNumber)
+	if err != nil {
+		return nil, err
+	}
+	if reservation == nil {
+		return nil, newBookingError(ErrCodeReservationNotFound, "%s does not exist", reservationNumber)
+	}
+
+	if reservation.UserID != userID {
+		return nil, newBookingError(ErrCodeNotOwner, "%s does not hold %s", userID, reservationNumber)
+	}
+	if reservation.currentStatus() != StatusBooked {
+		return nil, newBookingError(ErrCodeInvalidStatus, "%s is %s", reservationNumber, reservation.currentStatus())
+	}
+
+	key, err := getConfirmationKey(ctx, reservation.GroundID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%s has no confirmation key", reservation.GroundID)
+	}
+
+	privateKey, err := x509.ParseECPrivateKey(key.Key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode the confirmation key. %s", err.Error())
+	}
+
+	payload := encodeTokenPayload(reservation)
+	signature, err := signTokenPayload(privateKey, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConfirmationToken{
+		ReservationNumber: reservation.ReservationNumber,
+		GroundID:          reservation.GroundID,
+		Begin:             reservation.Begin,
+		End:               reservation.End,
+		GameCode:          reservation.GameCode,
+		TxID:              bookingTxID(reservation),
+		Token:             payload + "." + signature,
+	}, nil
+}
+
+// VerifyConfirmationToken is the query function that checks a token against the ledger.
+// A token is valid while its reservation is booked with the time, ground and game code it was issued for.
+// The signature is checked with the published public key, so any peer can verify a token.
+// params - token
+// returns the TokenVerification
+func (s *SmartContract) VerifyConfirmationToken(ctx contractapi.TransactionContextInterface, token string) (*TokenVerification, error) {
+	verification := new(TokenVerification)
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		verification.Verdict = TokenMalformed
+		return verification, nil
+	}
+
+	fields, ok := decodeTokenPayload(parts[0])
+	if !ok {
+		verification.Verdict = TokenMalformed
+		return verification, nil
+	}
+	verification.ReservationNumber = fields[1]
+
+	publicKey, err := getConfirmationPublicKey(ctx, fields[2])
+	if err != nil {
+		return nil, err
+	}
+	if publicKey == nil || !verifyTokenSignature(publicKey, parts[0], parts[1]) {
+		verification.Verdict = TokenBadSignature
+		return verification, nil
+	}
+
+	reservation, err := getReservation(ctx, fields[1])
+	if err != nil {
+		return nil, err
+	}
+	if reservation == nil {
+		verification.Verdict = TokenNotFound
+		return verification, nil
+	}
+	verification.Status = reservation.currentStatus()
+
+	current, _ := decodeTokenPayload(encodeTokenPayload(reservation))
+
+	switch {
+	case reservation.currentStatus() == StatusCancelled:
+		verification.Verdict = TokenCancelled
+	case current[2] != fields[2] || current[3] != fields[3] || current[4] != fields[4]:
+		verification.Verdict = TokenRescheduled
+	case current[5] != fields[5] || current[6] != fields[6]:
+		verification.Verdict = TokenMismatch
+	case reservation.currentStatus() != StatusBooked:
+		verification.Verdict = TokenNotBooked
+	default:
+		verification.Verdict = TokenValid
+		verification.Valid = true
+	}
+
+	return verification, nil
+}
+
+// encodeTokenPayload encodes the fields of the reservation a token is tied to
+func encodeTokenPayload(reservation *Reservation) string {
+	fields := []string{
+		tokenVersion,
+		reservation.ReservationNumber,
+		reservation.GroundID,
+		reservation.Begin.UTC().Format(slotKeyFormat),
+		reservation.End.UTC().Format(slotKeyFormat),
+		strconv.Itoa(reservation.GameCode),
+		bookingTxID(reservation),
+	}
+
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(fields, "|")))
+}
+
+// decodeTokenPayload decodes the fields of a token payload
+// returns false when the payload is not of tokenVersion
+func decodeTokenPayload(payload string) ([]string, bool) {
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, false
+	}
+
+	fields := strings.Split(string(decoded), "|")
+	if len(fields) != 7 || fields[0] != tokenVersion {
+		return nil, false
+	}
+
+	return fields, true
+}
+
+// parseConfirmationKey decodes the PEM block of a P-256 private key
+func parseConfirmationKey(keyAsPEM []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyAsPEM)
+	if block == nil {
+		return nil, fmt.Errorf("is not a PEM block")
+	}
+
+	var privateKey *ecdsa.PrivateKey
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("is not an EC private key. %s", err.Error())
+		}
+		privateKey = key
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("is not a PKCS #8 private key. %s", err.Error())
+		}
+
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("is not an ECDSA key")
+		}
+		privateKey = ecKey
+	default:
+		return nil, fmt.Errorf("holds a %s, not a private key", block.Type)
+	}
+
+	if privateKey.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("is not a P-256 key")
+	}
+
+	return privateKey, nil
+}
+
+// signTokenPayload signs the encoded payload with the confirmation key
+func signTokenPayload(privateKey *ecdsa.PrivateKey, payload string) (string, error) {
+	digest := sha256.Sum256([]byte(payload))
+
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("Failed to sign the token. %s", err.Error())
+	}
+
+	signature := make([]byte, tokenSignatureLength)
+	rAsBytes, sAsBytes := r.Bytes(), s.Bytes()
+	copy(signature[tokenSignatureLength/2-len(rAsBytes):tokenSignatureLength/2], rAsBytes)
+	copy(signature[tokenSignatureLength-len(sAsBytes):], sAsBytes)
+
+	return base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// verifyTokenSignature checks the signature of the encoded payload with the published public key
+func verifyTokenSignature(publicKey *ConfirmationPublicKey, payload string, encodedSignature string) bool {
+	block, _ := pem.Decode([]byte(publicKey.PublicKey))
+	if block == nil {
+		return false
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return false
+	}
+
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return false
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || len(signature) != tokenSignatureLength {
+		return false
+	}
+
+	r := new(big.Int).SetBytes(signature[:tokenSignatureLength/2])
+	s := new(big.Int).SetBytes(signature[tokenSignatureLength/2:])
+	digest := sha256.Sum256([]byte(payload))
+
+	return ecdsa.Verify(ecKey, digest[:], r, s)
+}
+
+// bookingTxID returns the ID of the transaction that booked the reservation,
+// or "" for a reservation made before the history was kept
+func bookingTxID(reservation *Reservation) string {
+	if len(reservation.History) == 0 {
+		return ""
+	}
+
+	return reservation.History[0].TxID
+}
+
+// getConfirmationKey reads the confirmation key of the ground from the implicit collection of its owner
+// returns nil without an error when the ground has none
+func getConfirmationKey(ctx contractapi.TransactionContextInterface, groundID string) (*ConfirmationKey, error) {
+	groundCompositeKey, _ := ctx.GetStub().CreateCompositeKey("ground", []string{groundID})
+	groundAsBytes, err := ctx.GetStub().GetState(groundCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+	if groundAsBytes == nil {
+		return nil, nil
+	}
+
+	ground, err := unmarshalGround(groundAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	if ground.OwnerMSP == "" {
+		return nil, nil
+	}
+
+	keyCompositeKey, _ := ctx.GetStub().CreateCompositeKey("confirmationKey", []string{groundID})
+	keyAsBytes, err := ctx.GetStub().GetPrivateData(implicitCollectionPrefix+ground.OwnerMSP, keyCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the private data. %s", err.Error())
+	}
+
+	if keyAsBytes == nil {
+		return nil, nil
+	}
+
+	confirmationKey := new(ConfirmationKey)
+	err = json.Unmarshal(keyAsBytes, confirmationKey)
+	if err != nil {
+		return nil, fmt.Errorf("confirmationKey Unmarshal Error: %s", err.Error())
+	}
+
+	return confirmationKey, nil
+}
+
+// getConfirmationPublicKey reads the published public key of the ground
+// returns nil without an error when the ground has none
+func getConfirmationPublicKey(ctx contractapi.TransactionContextInterface, groundID string) (*ConfirmationPublicKey, error) {
+	publicKeyCompositeKey, _ := ctx.GetStub().CreateCompositeKey("confirmationPublicKey", []string{groundID})
+	publicKeyAsBytes, err := ctx.GetStub().GetState(publicKeyCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if publicKeyAsBytes == nil {
+		return nil, nil
+	}
+
+	publicKey := new(ConfirmationPublicKey)
+	err = json.Unmarshal(publicKeyAsBytes, publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("confirmationPublicKey Unmarshal Error: %s", err.Error())
+	}
+
+	return publicKey, nil
+}
+
+// putConfirmationPublicKey publishes the public key of the ground
+func putConfirmationPublicKey(ctx contractapi.TransactionContextInterface, publicKey *ConfirmationPublicKey) error {
+	publicKey.SchemaVersion = schemaVersions["confirmationPublicKey"]
+
+	publicKeyCompositeKey, _ := ctx.GetStub().CreateCompositeKey("confirmationPublicKey", []string{publicKey.GroundID})
+	publicKeyAsBytes, err := json.Marshal(publicKey)
+	if err != nil {
+		return fmt.Errorf("confirmationPublicKey Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(publicKeyCompositeKey, publicKeyAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, publicKey.GroundID, publicKeyCompositeKey)
+}
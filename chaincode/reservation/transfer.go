@@ -0,0 +1,214 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// OfferTransfer is the invoke function that offers the reservation to another golfer.
+// The reservation keeps its owner until the other golfer calls AcceptTransfer.
+// Only the owner of the reservation, or the owner of the ground acting for them, may offer it.
+// params - reservation number, owner's userID, receiver's userID
+func (s *SmartContract) OfferTransfer(ctx contractapi.TransactionContextInterface, reservationNumber string, fromUserID string, toUserID string) error {
+	fmt.Println("OfferTransfer called")
+
+	if toUserID == "" || toUserID == fromUserID {
+		return newBookingError(ErrCodeInvalidRequest, "the reservation must be offered to another golfer")
+	}
+
+	reservation, err := getTransferableReservation(ctx, reservationNumber)
+	if err != nil {
+		return err
+	}
+
+	if reservation.UserID != fromUserID {
+		return newBookingError(ErrCodeNotOwner, "%s does not hold %s", fromUserID, reservationNumber)
+	}
+
+	ground, err := s.QueryGround(ctx, reservation.GroundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireUserOrOwner(ctx, ground, fromUserID)
+	if err != nil {
+		return err
+	}
+
+	reservation.PendingTransferTo = toUserID
+	err = appendHistory(ctx, reservation, HistoryTransferOffered, fromUserID, toUserID)
+	if err != nil {
+		return err
+	}
+
+	return putReservation(ctx, reservation)
+}
+
+// AcceptTransfer is the invoke function that makes the receiver of an offer the owner of the reservation.
+// The reservation number stays the same and a new game code is issued.
+// The booking policy of the ground applies to the receiver as to a new booking.
+// The unpaid shares of the participants are then owed to the receiver.
+// Only the receiver, or the owner of the ground acting for them, may accept it.
+// params - reservation number, receiver's userID
+func (s *SmartContract) AcceptTransfer(ctx contractapi.TransactionContextInterface, reservationNumber string, toUserID string) error {
+	fmt.Println("AcceptTransfer called")
+
+	reservation, err := getTransferableReservation(ctx, reservationNumber)
+	if err != nil {
+		return err
+	}
+
+	if reservation.PendingTransferTo == "" || reservation.PendingTransferTo != toUserID {
+		return newBookingError(ErrCodeNoTransferOffer, "%s is not offered to %s", reservationNumber, toUserID)
+	}
+
+	ground, err := s.QueryGround(ctx, reservation.GroundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireUserOrOwner(ctx, ground, toUserID)
+	if err != nil {
+		return err
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	tier, err := userTier(ctx, reservation.GroundID, toUserID, txTime)
+	if err != nil {
+		return err
+	}
+
+	players := reservation.Players
+	if players == 0 {
+		players = defaultPlayers
+	}
+
+	// the receiver takes the reservation as if booking it now
+	err = checkBookingPolicy(ctx, reservation.GroundID, toUserID, tier, reservation.Begin, players, txTime)
+	if err != nil {
+		return err
+	}
+
+	// the owner is part of the composite key, so the reservation moves to a new key
+	err = delReservation(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
+	fromUserID := reservation.UserID
+	reservation.UserID = toUserID
+	reservation.PendingTransferTo = ""
+	reservation.GameCode = txGameCode(ctx.GetStub().GetTxID())
+
+	err = transferShares(ctx, reservation, fromUserID, toUserID)
+	if err != nil {
+		return err
+	}
+
+	err = appendHistory(ctx, reservation, HistoryTransferred, fromUserID, toUserID)
+	if err != nil {
+		return err
+	}
+
+	err = putReservation(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
+	events := new(eventBatch)
+	err = events.add(EventReservationTransferred, reservation)
+	if err != nil {
+		return err
+	}
+
+	return events.emit(ctx)
+}
+
+// CancelTransfer is the invoke function that withdraws or declines a pending transfer offer
+// Only a party of the transfer, or the owner of the ground acting for them, may cancel it.
+// params - reservation number, userID of either the owner or the receiver
+func (s *SmartContract) CancelTransfer(ctx contractapi.TransactionContextInterface, reservationNumber string, userID string) error {
+	fmt.Println("CancelTransfer called")
+
+	reservation, err := getReservation(ctx, reservationNumber)
+	if err != nil {
+		return err
+	}
+	if reservation == nil {
+		return newBookingError(ErrCodeReservationNotFound, "%s does not exist", reservationNumber)
+	}
+
+	if reservation.PendingTransferTo == "" {
+		return newBookingError(ErrCodeNoTransferOffer, "%s has no transfer offer", reservationNumber)
+	}
+
+	if userID != reservation.UserID && userID != reservation.PendingTransferTo {
+		return newBookingError(ErrCodeNotOwner, "%s is not a party of the transfer", userID)
+	}
+
+	ground, err := s.QueryGround(ctx, reservation.GroundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireUserOrOwner(ctx, ground, userID)
+	if err != nil {
+		return err
+	}
+
+	toUserID := reservation.PendingTransferTo
+	reservation.PendingTransferTo = ""
+	err = appendHistory(ctx, reservation, HistoryTransferVoided, reservation.UserID, toUserID)
+	if err != nil {
+		return err
+	}
+
+	return putReservation(ctx, reservation)
+}
+
+// getTransferableReservation reads the reservation and checks that it may change hands
+func getTransferableReservation(ctx contractapi.TransactionContextInterface, reservationNumber string) (*Reservation, error) {
+	reservation, err := getReservation(ctx, reservationNumber)
+	if err != nil {
+		return nil, err
+	}
+	if reservation == nil {
+		return nil, newBookingError(ErrCodeReservationNotFound, "%s does not exist", reservationNumber)
+	}
+
+	// tournament groups are managed through their block booking
+	if reservation.BlockID != "" {
+		return nil, newBookingError(ErrCodeTransferDisabled, "%s belongs to block booking %s", reservationNumber, reservation.BlockID)
+	}
+
+	if reservation.currentStatus() != StatusBooked {
+		return nil, newBookingError(ErrCodeInvalidStatus, "%s is %s", reservationNumber, reservation.currentStatus())
+	}
+
+	bookingPolicy, err := getBookingPolicy(ctx, reservation.GroundID)
+	if err != nil {
+		return nil, err
+	}
+	if bookingPolicy != nil && bookingPolicy.TransfersDisabled {
+		return nil, newBookingError(ErrCodeTransferDisabled, "%s does not allow transfers", reservation.GroundID)
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !txTime.Before(reservation.Begin) {
+		return nil, newBookingError(ErrCodeAlreadyStarted, "%s has already started", reservationNumber)
+	}
+
+	return reservation, nil
+}
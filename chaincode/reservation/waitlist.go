@@ -0,0 +1,301 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// promotionHoldMinutes is how long the golfer promoted from the waitlist has to confirm the tee time
+const promotionHoldMinutes = 30
+
+// WaitlistEntry is the struct that queues a golfer for a tee time that is already taken.
+// When a reservation touching the time is cancelled or moved, the golfer who joined first and whose time is
+// then free is promoted: the time is held for them as a SlotHold, to be booked with ConfirmHold.
+type WaitlistEntry struct {
+	GroundID      string    `json:"groundID"`
+	WaitlistID    string    `json:"waitlistID"`
+	UserID        string    `json:"userID"`
+	Begin         time.Time `json:"begin"`
+	End           time.Time `json:"end"`
+	JoinedAt      time.Time `json:"joinedAt"`
+	SchemaVersion uint      `json:"schemaVersion"`
+}
+
+// playTime is the begin and the end of a play
+type playTime struct {
+	begin time.Time
+	end   time.Time
+}
+
+// JoinWaitlist is the invoke function that queues the golfer for a tee time that is taken.
+// The ID of the entry is the ID of this transaction. Only the golfer or the owner of the ground may queue them.
+// params - groundID, userID, begin and end time of the play
+// returns the WaitlistEntry
+func (s *SmartContract) JoinWaitlist(ctx contractapi.TransactionContextInterface, groundID string, userID string, begin string, end string) (*WaitlistEntry, error) {
+	fmt.Println("JoinWaitlist called")
+
+	beginTime, err := parseTime(begin)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "begin %s", err.Error())
+	}
+	endTime, err := parseTime(end)
+	if err != nil {
+		return nil, newBookingError(ErrCodeInvalidRequest, "end %s", err.Error())
+	}
+	err = checkPlayTime(beginTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return nil, newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireUserOrOwner(ctx, ground, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkOpeningHours(ground, beginTime)
+	if err != nil {
+		return nil, err
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !txTime.Before(beginTime) {
+		return nil, newBookingError(ErrCodeAlreadyStarted, "that time has already started")
+	}
+
+	isPossible, err := validateReservation(ctx, groundID, beginTime, endTime, "")
+	if err != nil {
+		return nil, fmt.Errorf("validate Error: %s", err.Error())
+	}
+	if isPossible {
+		return nil, newBookingError(ErrCodeInvalidRequest, "that time is free to book")
+	}
+
+	entry := &WaitlistEntry{
+		GroundID:   groundID,
+		WaitlistID: ctx.GetStub().GetTxID(),
+		UserID:     userID,
+		Begin:      beginTime,
+		End:        endTime,
+		JoinedAt:   txTime,
+	}
+
+	err = putWaitlistEntry(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// LeaveWaitlist is the invoke function that takes the golfer off the waitlist.
+// Only the golfer or the owner of the ground may take them off.
+// params - groundID, waitlistID, userID
+func (s *SmartContract) LeaveWaitlist(ctx contractapi.TransactionContextInterface, groundID string, waitlistID string, userID string) error {
+	fmt.Println("LeaveWaitlist called")
+
+	ground, err := s.QueryGround(ctx, groundID)
+	if err != nil {
+		return newBookingError(ErrCodeGroundNotFound, "%s", err.Error())
+	}
+
+	err = requireUserOrOwner(ctx, ground, userID)
+	if err != nil {
+		return err
+	}
+
+	entry, err := getWaitlistEntry(ctx, groundID, waitlistID)
+	if err != nil {
+		return err
+	}
+	if entry == nil || entry.UserID != userID {
+		return newBookingError(ErrCodeWaitlistNotFound, "%s has no waitlist entry %s", userID, waitlistID)
+	}
+
+	return deleteWaitlistEntry(ctx, groundID, waitlistID)
+}
+
+// QueryWaitlist returns the waitlist of the ground in the order the golfers joined it
+// params - groundID
+// returns the array of WaitlistEntry
+func (s *SmartContract) QueryWaitlist(ctx contractapi.TransactionContextInterface, groundID string) ([]*WaitlistEntry, error) {
+	return queryWaitlist(ctx, groundID)
+}
+
+// promoteWaitlist offers the time given up by the holder to the waitlist of the ground.
+// Every entry overlapping the freed time whose whole time is then free is promoted in the order the golfers
+// joined, unless it overlaps an entry promoted before it or a time in kept, which the caller still holds.
+// The slots of freedHolder count as free, as the caller has just deleted them.
+// params - groundID, holder of the freed slots, begin and end of the freed time, times still held, events of the transaction
+func promoteWaitlist(ctx contractapi.TransactionContextInterface, groundID string, freedHolder string, freedBegin, freedEnd time.Time, kept []playTime, events *eventBatch) error {
+	entries, err := queryWaitlist(ctx, groundID)
+	if err != nil {
+		return err
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	taken := append([]playTime{}, kept...)
+
+	for _, entry := range entries {
+		if !txTime.Before(entry.Begin) || !entry.Begin.Before(freedEnd) || !freedBegin.Before(entry.End) {
+			continue
+		}
+
+		overlaps := false
+		for _, play := range taken {
+			if entry.Begin.Before(play.end) && play.begin.Before(entry.End) {
+				overlaps = true
+			}
+		}
+		if overlaps {
+			continue
+		}
+
+		isPossible, err := validateReservation(ctx, groundID, entry.Begin, entry.End, freedHolder)
+		if err != nil {
+			return fmt.Errorf("validate Error: %s", err.Error())
+		}
+		if !isPossible {
+			continue
+		}
+
+		err = releaseExpiredHolds(ctx, groundID, entry.Begin, entry.End, txTime)
+		if err != nil {
+			return err
+		}
+
+		// the hold is named after the entry, so holds promoted in one transaction do not collide
+		hold := &SlotHold{
+			GroundID:  groundID,
+			HoldID:    entry.WaitlistID,
+			UserID:    entry.UserID,
+			Begin:     entry.Begin,
+			End:       entry.End,
+			ExpiresAt: txTime.Add(promotionHoldMinutes * time.Minute),
+		}
+
+		err = claimSlots(ctx, teeSlotKey, []string{groundID}, holdHolder(hold.HoldID), hold.Begin, hold.End)
+		if err != nil {
+			return err
+		}
+
+		err = putSlotHold(ctx, hold)
+		if err != nil {
+			return err
+		}
+
+		err = deleteWaitlistEntry(ctx, groundID, entry.WaitlistID)
+		if err != nil {
+			return err
+		}
+
+		err = events.add(EventWaitlistPromoted, hold)
+		if err != nil {
+			return err
+		}
+
+		taken = append(taken, playTime{begin: entry.Begin, end: entry.End})
+	}
+
+	return nil
+}
+
+// queryWaitlist returns the waitlist of the ground in the order the golfers joined it
+func queryWaitlist(ctx contractapi.TransactionContextInterface, groundID string) ([]*WaitlistEntry, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("waitlist", []string{groundID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	entries := []*WaitlistEntry{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		entry := new(WaitlistEntry)
+		err = json.Unmarshal(queryResponse.Value, entry)
+		if err != nil {
+			return nil, fmt.Errorf("waitlist Unmarshal Error: %s", err.Error())
+		}
+
+		entries = append(entries, entry)
+	}
+
+	// the keys are ordered by transaction ID, which says nothing about when the golfers joined
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].JoinedAt.Before(entries[j].JoinedAt)
+	})
+
+	return entries, nil
+}
+
+// getWaitlistEntry reads the waitlist entry
+// returns nil without an error when it does not exist
+func getWaitlistEntry(ctx contractapi.TransactionContextInterface, groundID string, waitlistID string) (*WaitlistEntry, error) {
+	entryCompositeKey, _ := ctx.GetStub().CreateCompositeKey("waitlist", []string{groundID, waitlistID})
+	entryAsBytes, err := ctx.GetStub().GetState(entryCompositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if entryAsBytes == nil {
+		return nil, nil
+	}
+
+	entry := new(WaitlistEntry)
+	err = json.Unmarshal(entryAsBytes, entry)
+	if err != nil {
+		return nil, fmt.Errorf("waitlist Unmarshal Error: %s", err.Error())
+	}
+
+	return entry, nil
+}
+
+// putWaitlistEntry writes the waitlist entry to the world state
+func putWaitlistEntry(ctx contractapi.TransactionContextInterface, entry *WaitlistEntry) error {
+	entry.SchemaVersion = schemaVersions["waitlist"]
+
+	entryCompositeKey, _ := ctx.GetStub().CreateCompositeKey("waitlist", []string{entry.GroundID, entry.WaitlistID})
+	entryAsBytes, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("waitlist Marshal Error: %s", err.Error())
+	}
+
+	err = ctx.GetStub().PutState(entryCompositeKey, entryAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return endorseByOwner(ctx, entry.GroundID, entryCompositeKey)
+}
+
+// deleteWaitlistEntry deletes the waitlist entry
+func deleteWaitlistEntry(ctx contractapi.TransactionContextInterface, groundID string, waitlistID string) error {
+	entryCompositeKey, _ := ctx.GetStub().CreateCompositeKey("waitlist", []string{groundID, waitlistID})
+
+	return ctx.GetStub().DelState(entryCompositeKey)
+}
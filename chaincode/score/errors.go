@@ -0,0 +1,47 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+)
+
+// Rejection codes returned by the game transactions.
+// The client receives them as the prefix of the error message, e.g. "GAME_NOT_FOUND: ...".
+const (
+	ErrCodeInvalidRequest  = "INVALID_REQUEST"
+	ErrCodeGameNotFound    = "GAME_NOT_FOUND"
+	ErrCodeInvalidStatus   = "INVALID_STATUS"
+	ErrCodeWrongGameCode   = "WRONG_GAME_CODE"
+	ErrCodeRosterFull      = "ROSTER_FULL"
+	ErrCodeRosterNotReady  = "ROSTER_NOT_READY"
+	ErrCodeNotPlayer       = "NOT_PLAYER"
+	ErrCodeAlreadyInRoster = "ALREADY_IN_ROSTER"
+	ErrCodeCourseNotFound  = "COURSE_NOT_FOUND"
+	ErrCodeInvalidHole     = "INVALID_HOLE"
+	ErrCodeScoreOutOfRange = "SCORE_OUT_OF_RANGE"
+	ErrCodeNoHandicap      = "HANDICAP_NOT_FOUND"
+	ErrCodeNotAuthorized   = "NOT_AUTHORIZED"
+)
+
+// GameError is the error that describes why a game transaction was rejected
+type GameError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error returns the code followed by the message
+func (e *GameError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// newGameError creates a GameError with the formatted message
+// params - rejection code, format and its arguments
+func newGameError(code string, format string, args ...interface{}) error {
+	return &GameError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,91 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Bounds of a handicap index; a plus handicap is stored as a negative index
+const (
+	minHandicapIndex = -10.0
+	maxHandicapIndex = 54.0
+)
+
+// handicapAuthorityAttribute is the certificate attribute, set to "true", of the clients that keep the handicap indexes
+const handicapAuthorityAttribute = "handicapAuthority"
+
+// HandicapIndex is the struct that informs the handicap index of a user.
+// Other chaincodes read it with QueryHandicapIndex, e.g. the reservation chaincode to pair golfers.
+type HandicapIndex struct {
+	UserID    string  `json:"userID"`
+	Index     float64 `json:"index"`
+	UpdatedTx string  `json:"updatedTx"`
+}
+
+// SetHandicapIndex is the invoke function that sets the handicap index of the user.
+// Only a client enrolled with the handicapAuthority attribute may set it, so golfers cannot set their own.
+// params - user's ID, handicap index(-10.0 to 54.0)
+func (s *SmartContract) SetHandicapIndex(ctx contractapi.TransactionContextInterface, userID string, index float64) error {
+	fmt.Println("SetHandicapIndex")
+
+	err := ctx.GetClientIdentity().AssertAttributeValue(handicapAuthorityAttribute, "true")
+	if err != nil {
+		return newGameError(ErrCodeNotAuthorized, "only a handicap authority may set a handicap index. %s", err.Error())
+	}
+
+	if userID == "" {
+		return fmt.Errorf("userID must not be empty")
+	}
+	if index < minHandicapIndex || index > maxHandicapIndex {
+		return fmt.Errorf("handicap index must be from %.1f to %.1f", minHandicapIndex, maxHandicapIndex)
+	}
+
+	handicapIndex := HandicapIndex{
+		UserID:    userID,
+		Index:     index,
+		UpdatedTx: ctx.GetStub().GetTxID(),
+	}
+
+	// create composite key for the handicap index
+	HandicapCompositeKey, _ := ctx.GetStub().CreateCompositeKey("handicap", []string{userID})
+	handicapAsBytes, err := json.Marshal(handicapIndex)
+	if err != nil {
+		return fmt.Errorf("handicapIndex Marshal Error: %s", err.Error())
+	}
+
+	return ctx.GetStub().PutState(HandicapCompositeKey, handicapAsBytes)
+}
+
+// QueryHandicapIndex is the query function that returns the handicap index of the user.
+// A user without an index is rejected with HANDICAP_NOT_FOUND.
+// params - user's ID
+// returns the HandicapIndex
+func (s *SmartContract) QueryHandicapIndex(ctx contractapi.TransactionContextInterface, userID string) (*HandicapIndex, error) {
+	fmt.Println("QueryHandicapIndex")
+
+	// get the Composite key for the handicap index
+	HandicapCompositeKey, _ := ctx.GetStub().CreateCompositeKey("handicap", []string{userID})
+	handicapAsBytes, err := ctx.GetStub().GetState(HandicapCompositeKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if handicapAsBytes == nil {
+		return nil, newGameError(ErrCodeNoHandicap, "%s has no handicap index", userID)
+	}
+
+	handicapIndex := new(HandicapIndex)
+	err = json.Unmarshal(handicapAsBytes, handicapIndex)
+	if err != nil {
+		return nil, fmt.Errorf("handicapIndex Unmarshal Error: %s", err.Error())
+	}
+
+	return handicapIndex, nil
+}
@@ -0,0 +1,219 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Status of a game.
+// A game is created by its first player, begins once its roster is ready, and ends finished or abandoned.
+const (
+	GameCreated    = "created"
+	GameInProgress = "in_progress"
+	GameFinished   = "finished"
+	GameAbandoned  = "abandoned"
+)
+
+// maxRosterSize is the largest group a game can be created for
+const maxRosterSize = 8
+
+// gameNumberPrefix starts the number of every game
+const gameNumberPrefix = "GAME"
+
+// legacyRosterSize is the roster of a game started before the lifecycle, which had a slot for four players
+const legacyRosterSize = 4
+
+// GameTransition is the struct that records a change of the status of a game
+type GameTransition struct {
+	Status    string    `json:"status"`
+	UserID    string    `json:"userID"`
+	TxID      string    `json:"txID"`
+	Timestamp time.Time `json:"timestamp"`
+	Reason    string    `json:"reason,omitempty" metadata:"reason,optional"`
+}
+
+// CreateGame is the invoke function that creates a game with a new game number, with the caller as its first player.
+// The game is numbered by its transaction, so games created at the same time do not conflict over a shared counter.
+// The other players join it with JoinGame and the shared game code.
+// The layout of the course is read from the reservation chaincode and kept with the game to check its scores.
+// params - ground ID, user's ID, game code, course ID, number of players in the roster(1 to 8)
+// returns the GameInfo
+func (s *SmartContract) CreateGame(ctx contractapi.TransactionContextInterface, groundID, userID, gameCode, courseID string, rosterSize uint) (*GameInfo, error) {
+	fmt.Println("CreateGame")
+
+	if groundID == "" || userID == "" || gameCode == "" {
+		return nil, newGameError(ErrCodeInvalidRequest, "groundID, userID and gameCode must not be empty")
+	}
+	if rosterSize == 0 || rosterSize > maxRosterSize {
+		return nil, newGameError(ErrCodeInvalidRequest, "rosterSize must be from 1 to %d", maxRosterSize)
+	}
+
+	course, err := queryCourseLayout(ctx, groundID, courseID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the transaction ID is unique on the channel, unlike the numbers of the games of the first release
+	gameNumber := gameNumberPrefix + ctx.GetStub().GetTxID()
+	fmt.Println("gameNumber is " + gameNumber)
+
+	gameInfo := &GameInfo{
+		GroundID:   groundID,
+		Players:    []string{userID},
+		RosterSize: rosterSize,
+		GameNumber: gameNumber,
+		GameCode:   gameCode,
+		IsReady:    rosterSize == 1,
+		History:    []*GameTransition{},
+		Course:     course,
+	}
+
+	err = changeGameStatus(ctx, gameInfo, GameCreated, userID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	err = putGameInfo(ctx, gameInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return gameInfo, nil
+}
+
+// JoinGame is the invoke function that adds the user to the roster of a created game.
+// The game is ready once the roster is full.
+// params - ground ID, game number, user's ID, game code
+func (s *SmartContract) JoinGame(ctx contractapi.TransactionContextInterface, groundID, gameNumber, userID, gameCode string) error {
+	fmt.Println("JoinGame")
+
+	if userID == "" {
+		return newGameError(ErrCodeInvalidRequest, "userID must not be empty")
+	}
+
+	gameInfo, err := getGameInfo(ctx, groundID, gameNumber)
+	if err != nil {
+		return err
+	}
+
+	if gameInfo.Status != GameCreated {
+		return newGameError(ErrCodeInvalidStatus, "%s is %s", gameNumber, gameInfo.Status)
+	}
+	if gameInfo.GameCode != gameCode {
+		return newGameError(ErrCodeWrongGameCode, "the game code of %s does not match", gameNumber)
+	}
+	if gameInfo.playerIndex(userID) >= 0 {
+		return newGameError(ErrCodeAlreadyInRoster, "%s already plays %s", userID, gameNumber)
+	}
+	if uint(len(gameInfo.Players)) >= gameInfo.RosterSize {
+		return newGameError(ErrCodeRosterFull, "%s has %d players", gameNumber, gameInfo.RosterSize)
+	}
+
+	gameInfo.Players = append(gameInfo.Players, userID)
+	gameInfo.IsReady = uint(len(gameInfo.Players)) == gameInfo.RosterSize
+
+	return putGameInfo(ctx, gameInfo)
+}
+
+// BeginRound is the invoke function that starts the round of a ready game, after which the scores are kept
+// params - ground ID, game number, user's ID of a player
+func (s *SmartContract) BeginRound(ctx contractapi.TransactionContextInterface, groundID, gameNumber, userID string) error {
+	fmt.Println("BeginRound")
+
+	gameInfo, err := getPlayerGame(ctx, groundID, gameNumber, userID, GameCreated)
+	if err != nil {
+		return err
+	}
+
+	if !gameInfo.IsReady {
+		return newGameError(ErrCodeRosterNotReady, "%s has %d of %d players", gameNumber, len(gameInfo.Players), gameInfo.RosterSize)
+	}
+
+	err = changeGameStatus(ctx, gameInfo, GameInProgress, userID, "")
+	if err != nil {
+		return err
+	}
+
+	return putGameInfo(ctx, gameInfo)
+}
+
+// FinishRound is the invoke function that ends the round of a game in progress, after which the scores are final
+// params - ground ID, game number, user's ID of a player
+func (s *SmartContract) FinishRound(ctx contractapi.TransactionContextInterface, groundID, gameNumber, userID string) error {
+	fmt.Println("FinishRound")
+
+	gameInfo, err := getPlayerGame(ctx, groundID, gameNumber, userID, GameInProgress)
+	if err != nil {
+		return err
+	}
+
+	err = changeGameStatus(ctx, gameInfo, GameFinished, userID, "")
+	if err != nil {
+		return err
+	}
+
+	return putGameInfo(ctx, gameInfo)
+}
+
+// AbandonRound is the invoke function that ends a game that was not played out, before or during the round
+// params - ground ID, game number, user's ID of a player, reason
+func (s *SmartContract) AbandonRound(ctx contractapi.TransactionContextInterface, groundID, gameNumber, userID, reason string) error {
+	fmt.Println("AbandonRound")
+
+	gameInfo, err := getPlayerGame(ctx, groundID, gameNumber, userID, GameCreated, GameInProgress)
+	if err != nil {
+		return err
+	}
+
+	err = changeGameStatus(ctx, gameInfo, GameAbandoned, userID, reason)
+	if err != nil {
+		return err
+	}
+
+	return putGameInfo(ctx, gameInfo)
+}
+
+// getPlayerGame reads a game the user plays, which must be in one of the given statuses
+func getPlayerGame(ctx contractapi.TransactionContextInterface, groundID, gameNumber, userID string, statuses ...string) (*GameInfo, error) {
+	gameInfo, err := getGameInfo(ctx, groundID, gameNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	if gameInfo.playerIndex(userID) < 0 {
+		return nil, newGameError(ErrCodeNotPlayer, "%s does not play %s", userID, gameNumber)
+	}
+
+	for _, status := range statuses {
+		if gameInfo.Status == status {
+			return gameInfo, nil
+		}
+	}
+
+	return nil, newGameError(ErrCodeInvalidStatus, "%s is %s", gameNumber, gameInfo.Status)
+}
+
+// changeGameStatus sets the status of the game and records the change in its history
+func changeGameStatus(ctx contractapi.TransactionContextInterface, gameInfo *GameInfo, status, userID, reason string) error {
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return fmt.Errorf("Failed to get the transaction timestamp. %s", err.Error())
+	}
+
+	gameInfo.Status = status
+	gameInfo.History = append(gameInfo.History, &GameTransition{
+		Status:    status,
+		UserID:    userID,
+		TxID:      ctx.GetStub().GetTxID(),
+		Timestamp: time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC(),
+		Reason:    reason,
+	})
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
@@ -16,38 +17,63 @@ type SmartContract struct {
 	contractapi.Contract
 }
 
-// GameInfo is the structure that informs the game information
+// GameInfo is the structure that informs the game information.
+// Players lists the user IDs of the game in the order they joined it, and may hold any number of players.
+// The game is ready once RosterSize players have joined, and History records every change of Status.
 type GameInfo struct {
-	GroundID   string `json:"groundID"`
-	User1ID    string `json:"user1ID"`
-	User2ID    string `json:"user2ID"`
-	User3ID    string `json:"user3ID"`
-	User4ID    string `json:"user4ID"`
-	GameNumber string `json:"gameNumber"`
-	GameCode   string `json:"gameCode"`
-	IsReady    bool `json:"isReady"`
-}
-
-// GameNumberKey is the struct containing a gameNumber key and index
-type GameNumberKey struct {
-	Key string
-	Idx int
+	GroundID   string            `json:"groundID"`
+	Players    []string          `json:"players"`
+	RosterSize uint              `json:"rosterSize"`
+	GameNumber string            `json:"gameNumber"`
+	GameCode   string            `json:"gameCode"`
+	IsReady    bool              `json:"isReady"`
+	Status     string            `json:"status"`
+	History    []*GameTransition `json:"history"`
+	Course     *CourseLayout     `json:"course,omitempty" metadata:"course,optional"`
+
+	// LegacySlots are the User1ID..User4ID of a game written before Players, which its old hole scores
+	// and agreements are numbered by. They are kept with the game, so the old records still read the same
+	// players after the game is saved again.
+	LegacySlots []string `json:"legacySlots,omitempty" metadata:"legacySlots,optional"`
 }
 
 // HoleScore is the struct that informs hole number, each user score and consensus result.
 // All user achive consensus, Validated is true
 type HoleScore struct {
-	HoleNumber string `json:"holeNumber"`
-	User1Score string `json:"user1Score"`
-	User2Score string `json:"user2Score"`
-	User3Score string `json:"user3Score"`
-	User4Score string `json:"user4Score"`
-	Validated  bool   `json:"validated"`
+	HoleNumber string         `json:"holeNumber"`
+	Scores     []*PlayerScore `json:"scores"`
+	Validated  bool           `json:"validated"`
+}
+
+// PlayerScore is the struct that informs the strokes of a player on a hole
+type PlayerScore struct {
+	UserID string `json:"userID"`
+	Score  int    `json:"score"`
 }
 
 // Agreement is the struct that informs each user's agreement the score
 type Agreement struct {
-	HoleNumber string `json:"holeNumber"`
+	HoleNumber string             `json:"holeNumber"`
+	Agreements []*PlayerAgreement `json:"agreements"`
+}
+
+// PlayerAgreement is the struct that informs whether a player agrees the scores of a hole
+type PlayerAgreement struct {
+	UserID string `json:"userID"`
+	Agree  string `json:"agree"`
+}
+
+// fourPlayerRecord is the layout of the records written before the player list,
+// with a field for each of four players numbered 1 to 4
+type fourPlayerRecord struct {
+	User1ID    string `json:"user1ID"`
+	User2ID    string `json:"user2ID"`
+	User3ID    string `json:"user3ID"`
+	User4ID    string `json:"user4ID"`
+	User1Score string `json:"user1Score"`
+	User2Score string `json:"user2Score"`
+	User3Score string `json:"user3Score"`
+	User4Score string `json:"user4Score"`
 	User1Agree string `json:"user1Agree"`
 	User2Agree string `json:"user2Agree"`
 	User3Agree string `json:"user3Agree"`
@@ -60,117 +86,17 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 	return nil
 }
 
-/*
-// StartGame is the invoke function that starts the gaim with user1, 2, 3, and 4
-// params - ground ID, users ID(4)
-func (s *SmartContract) StartGame(ctx contractapi.TransactionContextInterface, groundID, user1, user2, user3, user4 string, isReady bool) error {
-	fmt.Println("Start Game")
-
-	// get the unique game number
-	var gameNumberKey *GameNumberKey
-	gameNumberKey = s.GenerateKey(ctx, "latestKey")
-	keyidx := strconv.Itoa(gameNumberKey.Idx)
-	fmt.Println("Key : " + gameNumberKey.Key + ", Idx : " + keyidx)
-
-	var gameNumber = gameNumberKey.Key + keyidx
-	fmt.Println("gameNumberKey is " + gameNumber)
-
-	// create composite key for the gameInfo
-	GameCompositeKey, _ := ctx.GetStub().CreateCompositeKey("game", []string{groundID, gameNumber})
-
-	gameInfo := GameInfo{
-		GroundID: groundId,
-		GameNumber: gameNumber,
-		User1ID: user1,
-		User2ID: user2,
-		User3ID: user3,
-		User4ID: user4,
-		IsReady: isReady
-	}
-	
-	if gameInfo.User4ID != user4 {
-		gameInfo.IsReady = false
-	} else {
-		gameInfo.IsReady = true
-	}
-
-	gameInfoAsBytes, err := json.Marshal(gameInfo)
-	if err != nil {
-		return fmt.Errorf("gameInfo Marshal Error: %s", err.Error())
-	}
-	
-
-	// update game number
-	gameNumberKeyAsBytes, _ := json.Marshal(gameNumberKey)
-	ctx.GetStub().PutState("latestKey", gameNumberKeyAsBytes)
-
-	
-	// update gameInfo
-	return ctx.GetStub().PutState(GameCompositeKey, gameInfoAsBytes)
-}
-*/
-
-func (s *SmartContract) StartGame(ctx contractapi.TransactionContextInterface, groundID, user, userNumber, gameCode string) error {
-	fmt.Println("Start Game")
-
-	// get the unique game number
-	var gameNumberKey *GameNumberKey
-	gameNumberKey = s.GenerateKey(ctx, "latestKey")
-	keyidx := strconv.Itoa(gameNumberKey.Idx)
-	fmt.Println("Key : " + gameNumberKey.Key + ", Idx : " + keyidx)
-
-	var gameNumber = gameNumberKey.Key + keyidx
-	fmt.Println("gameNumberKey is " + gameNumber)
-
-	// create composite key for the gameInfo
-	GameCompositeKey, _ := ctx.GetStub().CreateCompositeKey("game", []string{groundID, gameNumber})
-	gameInfo, err := s.QueryGameInfo(ctx, groundID, gameNumber)
-	if gameInfo == nil {
-		gameInfo = &GameInfo{}
-	}
-	gameInfo.GroundID = groundID
-	gameInfo.GameNumber = gameNumber
-	if userNumber == "1"{
-		gameInfo.User1ID = user
-	} else if userNumber == "2"{
-		gameInfo.User2ID = user
-	} else if userNumber == "3" {
-		gameInfo.User3ID = user
-	} else if userNumber == "4" {
-		gameInfo.User4ID = user
-	}
-	gameInfo.GameCode = gameCode
-
-	if gameInfo.User4ID != user {
-		gameInfo.IsReady = false
-	} else {
-		gameInfo.IsReady = true
-	}
-
-	gameInfoAsBytes, err := json.Marshal(gameInfo)
-	if err != nil {
-		return fmt.Errorf("gameInfo Marshal Error: %s", err.Error())
-	}
-	
-
-	// update next game number
-	if gameInfo.GameCode != gameCode  {
-	gameNumberKeyAsBytes, _ := json.Marshal(gameNumberKey)
-	ctx.GetStub().PutState("latestKey", gameNumberKeyAsBytes)
-	}
-
-	
-	// update gameInfo
-	return ctx.GetStub().PutState(GameCompositeKey, gameInfoAsBytes)
-}
-
-
 // QueryGameInfo returns the gameInfo stored in the world state with given IDs and gameNumber
-// params - ground ID, user's ID, and unique game number
+// params - ground ID, and unique game number
 // returns the GameInfo
 func (s *SmartContract) QueryGameInfo(ctx contractapi.TransactionContextInterface, groundID, gameNumber string) (*GameInfo, error) {
 	fmt.Println("QueryGameInfo")
 
+	return getGameInfo(ctx, groundID, gameNumber)
+}
+
+// getGameInfo reads the game
+func getGameInfo(ctx contractapi.TransactionContextInterface, groundID, gameNumber string) (*GameInfo, error) {
 	// get the Composite key for the gameInfo
 	GameCompositeKey, _ := ctx.GetStub().CreateCompositeKey("game", []string{groundID, gameNumber})
 	gameInfoAsBytes, err := ctx.GetStub().GetState(GameCompositeKey)
@@ -180,174 +106,207 @@ func (s *SmartContract) QueryGameInfo(ctx contractapi.TransactionContextInterfac
 	}
 
 	if gameInfoAsBytes == nil {
-		return nil, fmt.Errorf("%s does not exist", gameNumber)
+		return nil, newGameError(ErrCodeGameNotFound, "%s does not exist", gameNumber)
 	}
 
-	gameInfo := new(GameInfo)
-	_ = json.Unmarshal(gameInfoAsBytes, gameInfo)
-
-	return gameInfo, nil
+	return unmarshalGameInfo(gameInfoAsBytes)
 }
 
-// SetScore is the invoke function that sets the user's score with given hole and user number
-// params - unique game number, hole's number, user's Number(ordered 1,2,3,4) and score
-func (s *SmartContract) SetScore(ctx contractapi.TransactionContextInterface, gameNumber, holeNumber, userNumber, score string) error {
+// SetScore is the invoke function that sets the player's strokes on the hole while the round is in progress.
+// The strokes must be from 1 to double the par of the hole plus the extra strokes of the ground's ScoringRule.
+// The scores of a validated hole are final.
+// params - ground ID, unique game number, hole's number, user's ID and strokes
+func (s *SmartContract) SetScore(ctx contractapi.TransactionContextInterface, groundID, gameNumber, holeNumber, userID string, score int) error {
 	fmt.Println("SetScore")
 
-	// create composite key for the hole score
-	HoleScoreCompositeKey, _ := ctx.GetStub().CreateCompositeKey("holeScore", []string{gameNumber, holeNumber})
+	gameInfo, err := getPlayerGame(ctx, groundID, gameNumber, userID, GameInProgress)
+	if err != nil {
+		return err
+	}
+
+	holeNumber, par, err := gameInfo.checkHoleNumber(holeNumber)
+	if err != nil {
+		return err
+	}
+
+	scoringRule, err := getScoringRule(ctx, groundID)
+	if err != nil {
+		return err
+	}
+
+	maxScore := int(2*par + scoringRule.ExtraStrokes)
+	if score < 1 || score > maxScore {
+		return newGameError(ErrCodeScoreOutOfRange, "the score of hole %s must be from 1 to %d", holeNumber, maxScore)
+	}
 
 	// get the holeScore
-	holeScore, err := s.QueryScore(ctx, gameNumber, holeNumber)
+	holeScore, err := getHoleScore(ctx, gameInfo, holeNumber)
+	if err != nil {
+		return err
+	}
 	// initialize the hole score
 	if holeScore == nil {
 		holeScore = &HoleScore{
 			HoleNumber: holeNumber,
+			Scores:     []*PlayerScore{},
 			Validated:  false,
 		}
 	}
-	// update holeScore with given userNumber and score
-	if userNumber == "1" {
-		holeScore.User1Score = score
-	} else if userNumber == "2" {
-		holeScore.User2Score = score
-	} else if userNumber == "3" {
-		holeScore.User3Score = score
-	} else if userNumber == "4" {
-		holeScore.User4Score = score
+	if holeScore.Validated {
+		return newGameError(ErrCodeInvalidStatus, "hole %s of %s is already validated", holeNumber, gameNumber)
 	}
 
-	holeScoreAsBytes, err := json.Marshal(holeScore)
-	if err != nil {
-		return fmt.Errorf("holseScore Marshal Error: %s", err.Error())
+	// update holeScore with given user's score
+	playerScore := holeScore.playerScore(userID)
+	if playerScore == nil {
+		playerScore = &PlayerScore{UserID: userID}
+		holeScore.Scores = append(holeScore.Scores, playerScore)
 	}
+	playerScore.Score = score
 
-	return ctx.GetStub().PutState(HoleScoreCompositeKey, holeScoreAsBytes)
+	return putHoleScore(ctx, gameNumber, holeScore)
 }
 
 // QueryScore is the query function that returns the HoleScore
-// params - gameNumber, holeNumber
+// params - groundID, gameNumber, holeNumber
 // returns the HoleScore
-func (s *SmartContract) QueryScore(ctx contractapi.TransactionContextInterface, gameNumber, holeNumber string) (*HoleScore, error) {
+func (s *SmartContract) QueryScore(ctx contractapi.TransactionContextInterface, groundID, gameNumber, holeNumber string) (*HoleScore, error) {
 	fmt.Println("QueryScore")
 
-	// create composite key for the holeScore
-	HoleScoreCompositeKey, _ := ctx.GetStub().CreateCompositeKey("holeScore", []string{gameNumber, holeNumber})
-	holeScoreAsBytes, err := ctx.GetStub().GetState(HoleScoreCompositeKey)
+	gameInfo, err := s.QueryGameInfo(ctx, groundID, gameNumber)
+	if err != nil {
+		return nil, err
+	}
 
+	holeScore, err := getHoleScore(ctx, gameInfo, holeNumber)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+		return nil, err
 	}
 
-	if holeScoreAsBytes == nil {
+	if holeScore == nil {
 		return nil, fmt.Errorf("%s does not exist", gameNumber)
 	}
 
-	holeScore := new(HoleScore)
-	_ = json.Unmarshal(holeScoreAsBytes, holeScore)
-
 	return holeScore, nil
 }
 
-// AgreeScore is the invoke function that updates the Agreement with given userNumber and agreement
-// params - gameNumber, holeNumber, userNumber, and user's agreement status
-func (s *SmartContract) AgreeScore(ctx contractapi.TransactionContextInterface, gameNumber, holeNumber, userNumber, isAgreed string) error {
+// AgreeScore is the invoke function that updates the Agreement with given user's agreement.
+// When every player agrees, the HoleScore is validated.
+// params - groundID, gameNumber, holeNumber, user's ID, and user's agreement status("agree")
+func (s *SmartContract) AgreeScore(ctx contractapi.TransactionContextInterface, groundID, gameNumber, holeNumber, userID, isAgreed string) error {
 	fmt.Println("AgreeScore")
 
-	// create composite key for the agreement
-	AgreementCompositeKey, _ := ctx.GetStub().CreateCompositeKey("agreement", []string{gameNumber, holeNumber})
+	gameInfo, err := getPlayerGame(ctx, groundID, gameNumber, userID, GameInProgress)
+	if err != nil {
+		return err
+	}
+
+	holeNumber, _, err = gameInfo.checkHoleNumber(holeNumber)
+	if err != nil {
+		return err
+	}
 
 	// get the Agreement
-	agreement, err := s.QueryAgreement(ctx, gameNumber, holeNumber)
+	agreement, err := getAgreement(ctx, gameInfo, holeNumber)
+	if err != nil {
+		return err
+	}
 	// initialize the Agreement
 	if agreement == nil {
-		agreement = &Agreement{}
-	}
-	// update the Agreement with given user number and agreement status
-	if userNumber == "1" {
-		agreement.User1Agree = isAgreed
-	} else if userNumber == "2" {
-		agreement.User2Agree = isAgreed
-	} else if userNumber == "3" {
-		agreement.User3Agree = isAgreed
-	} else if userNumber == "4" {
-		agreement.User4Agree = isAgreed
+		agreement = &Agreement{
+			HoleNumber: holeNumber,
+			Agreements: []*PlayerAgreement{},
+		}
 	}
 
-	// when all user agree the score, call the function ValidateScore.
-	if agreement.User1Agree == "agree" && agreement.User2Agree == "agree" && agreement.User3Agree == "agree" && agreement.User4Agree == "agree" {
-		fmt.Println("All user agrees the score")
-		s.ValidateScore(ctx, gameNumber, holeNumber)
+	// update the Agreement with given user's agreement status
+	playerAgreement := agreement.playerAgreement(userID)
+	if playerAgreement == nil {
+		playerAgreement = &PlayerAgreement{UserID: userID}
+		agreement.Agreements = append(agreement.Agreements, playerAgreement)
 	}
+	playerAgreement.Agree = isAgreed
+
 	agreementAsBytes, err := json.Marshal(agreement)
 	if err != nil {
-		return fmt.Errorf("holseScore Marshal Error: %s", err.Error())
+		return fmt.Errorf("agreement Marshal Error: %s", err.Error())
+	}
+
+	// create composite key for the agreement
+	AgreementCompositeKey, _ := ctx.GetStub().CreateCompositeKey("agreement", []string{gameNumber, holeNumber})
+	err = ctx.GetStub().PutState(AgreementCompositeKey, agreementAsBytes)
+	if err != nil {
+		return err
 	}
 
-	return ctx.GetStub().PutState(AgreementCompositeKey, agreementAsBytes)
+	// when all user agree the score, validate the hole score
+	if agreement.allAgree(gameInfo.Players) {
+		fmt.Println("All user agrees the score")
+		return validateHoleScore(ctx, gameInfo, holeNumber)
+	}
+
+	return nil
 }
 
 // QueryAgreement query function that returns the Agreement
-// params - gameNumber, holeNumber
+// params - groundID, gameNumber, holeNumber
 // returns the Agreement
-func (s *SmartContract) QueryAgreement(ctx contractapi.TransactionContextInterface, gameNumber, holeNumber string) (*Agreement, error) {
+func (s *SmartContract) QueryAgreement(ctx contractapi.TransactionContextInterface, groundID, gameNumber, holeNumber string) (*Agreement, error) {
 	fmt.Println("QueryAgreement")
 
-	// create composite key for the Agreement
-	AgreementCompositeKey, _ := ctx.GetStub().CreateCompositeKey("agreement", []string{gameNumber, holeNumber})
-	agreementAsBytes, err := ctx.GetStub().GetState(AgreementCompositeKey)
+	gameInfo, err := s.QueryGameInfo(ctx, groundID, gameNumber)
+	if err != nil {
+		return nil, err
+	}
 
+	agreement, err := getAgreement(ctx, gameInfo, holeNumber)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+		return nil, err
 	}
 
-	if agreementAsBytes == nil {
+	if agreement == nil {
 		return nil, fmt.Errorf("%s does not exist", gameNumber)
 	}
 
-	agreement := new(Agreement)
-	_ = json.Unmarshal(agreementAsBytes, agreement)
-
 	return agreement, nil
 }
 
 // ValidateScore is the invoke function that update the HoleScore's Validated
-// params - gameNumber, holeNumber
-func (s *SmartContract) ValidateScore(ctx contractapi.TransactionContextInterface, gameNumber, holeNumber string) error {
+// once every player of the game agrees the score
+// params - groundID, gameNumber, holeNumber
+func (s *SmartContract) ValidateScore(ctx contractapi.TransactionContextInterface, groundID, gameNumber, holeNumber string) error {
 	fmt.Println("ValidateScore")
 
-	// All user must be agree the score
-	agreement, err := s.QueryAgreement(ctx, gameNumber, holeNumber)
-	if agreement == nil {
-		return fmt.Errorf("all must be agree the score, %s", err.Error())
-	}
-
-	if agreement.User1Agree == "agree" && agreement.User2Agree == "agree" && agreement.User3Agree == "agree" && agreement.User4Agree == "agree" {
-		fmt.Println("All user agrees the score")
-		s.ValidateScore(ctx, gameNumber, holeNumber)
+	gameInfo, err := s.QueryGameInfo(ctx, groundID, gameNumber)
+	if err != nil {
+		return err
 	}
-
-	// create composite key for the holeScore
-	HoleScoreCompositeKey, _ := ctx.GetStub().CreateCompositeKey("holeScore", []string{gameNumber, holeNumber})
-	holeScore, err := s.QueryScore(ctx, gameNumber, holeNumber)
-	if holeScore == nil {
-		return nil
+	if gameInfo.Status != GameInProgress {
+		return newGameError(ErrCodeInvalidStatus, "%s is %s", gameNumber, gameInfo.Status)
 	}
 
-	// update Validated
-	holeScore.Validated = true
-	holeScoreAsBytes, err := json.Marshal(holeScore)
+	// All user must be agree the score
+	agreement, err := getAgreement(ctx, gameInfo, holeNumber)
 	if err != nil {
-		return fmt.Errorf("holseScore Marshal Error: %s", err.Error())
+		return err
+	}
+	if agreement == nil || !agreement.allAgree(gameInfo.Players) {
+		return fmt.Errorf("all must be agree the score of hole %s", holeNumber)
 	}
 
-	return ctx.GetStub().PutState(HoleScoreCompositeKey, holeScoreAsBytes)
+	return validateHoleScore(ctx, gameInfo, holeNumber)
 }
 
-// QueryTotalGameScore is the query function that returns the total game score
-// params - gameNumber
-func (s *SmartContract) QueryTotalGameScore(ctx contractapi.TransactionContextInterface, gameNumber string) ([]*HoleScore, error) {
+// QueryTotalGameScore is the query function that returns the validated scores of every hole
+// params - groundID, gameNumber
+func (s *SmartContract) QueryTotalGameScore(ctx contractapi.TransactionContextInterface, groundID, gameNumber string) ([]*HoleScore, error) {
 	fmt.Println("QueryTotalGameScore")
+
+	gameInfo, err := s.QueryGameInfo(ctx, groundID, gameNumber)
+	if err != nil {
+		return nil, err
+	}
+
 	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("holeScore", []string{gameNumber})
 
 	if err != nil {
@@ -355,7 +314,7 @@ func (s *SmartContract) QueryTotalGameScore(ctx contractapi.TransactionContextIn
 	}
 	defer resultsIterator.Close()
 
-	var holesScore []*HoleScore
+	holesScore := []*HoleScore{}
 
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
@@ -364,48 +323,244 @@ func (s *SmartContract) QueryTotalGameScore(ctx contractapi.TransactionContextIn
 			return nil, err
 		}
 
-		var holeScore HoleScore
-
-		_ = json.Unmarshal(queryResponse.Value, &holeScore)
+		holeScore, err := unmarshalHoleScore(queryResponse.Value, gameInfo)
+		if err != nil {
+			return nil, err
+		}
 		if holeScore.Validated {
-			holesScore = append(holesScore, &holeScore)
+			holesScore = append(holesScore, holeScore)
 		}
 	}
 
 	return holesScore, nil
 }
 
-// GenerateKey is the function that generate unique game key
-func (s *SmartContract) GenerateKey(ctx contractapi.TransactionContextInterface, key string) *GameNumberKey {
-	fmt.Println("GenerateKey")
-	var isFirst bool = false
+// playerIndex returns the position of the user in the players, or -1 when the user does not play the game
+func (gameInfo *GameInfo) playerIndex(userID string) int {
+	for i, player := range gameInfo.Players {
+		if player == userID {
+			return i
+		}
+	}
+
+	return -1
+}
 
-	gameNumberKeyAsBytes, err := ctx.GetStub().GetState(key)
+// playerScore returns the entry of the user, or nil when the user has no score on the hole
+func (holeScore *HoleScore) playerScore(userID string) *PlayerScore {
+	for _, playerScore := range holeScore.Scores {
+		if playerScore.UserID == userID {
+			return playerScore
+		}
+	}
+
+	return nil
+}
+
+// playerAgreement returns the entry of the user, or nil when the user has not answered
+func (agreement *Agreement) playerAgreement(userID string) *PlayerAgreement {
+	for _, playerAgreement := range agreement.Agreements {
+		if playerAgreement.UserID == userID {
+			return playerAgreement
+		}
+	}
+
+	return nil
+}
+
+// allAgree reports whether every player agrees the scores of the hole
+func (agreement *Agreement) allAgree(players []string) bool {
+	if len(players) == 0 {
+		return false
+	}
+
+	for _, player := range players {
+		playerAgreement := agreement.playerAgreement(player)
+		if playerAgreement == nil || playerAgreement.Agree != "agree" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validateHoleScore marks the scores of the hole agreed
+func validateHoleScore(ctx contractapi.TransactionContextInterface, gameInfo *GameInfo, holeNumber string) error {
+	holeScore, err := getHoleScore(ctx, gameInfo, holeNumber)
+	if err != nil {
+		return err
+	}
+	if holeScore == nil {
+		return nil
+	}
+
+	// update Validated
+	holeScore.Validated = true
+
+	return putHoleScore(ctx, gameInfo.GameNumber, holeScore)
+}
+
+// putGameInfo writes the game
+func putGameInfo(ctx contractapi.TransactionContextInterface, gameInfo *GameInfo) error {
+	// create composite key for the gameInfo
+	GameCompositeKey, _ := ctx.GetStub().CreateCompositeKey("game", []string{gameInfo.GroundID, gameInfo.GameNumber})
+	gameInfoAsBytes, err := json.Marshal(gameInfo)
 	if err != nil {
-		fmt.Println(err.Error())
-	}
-	var gameNumberKey *GameNumberKey
-	if gameNumberKeyAsBytes == nil {
-		isFirst = true
-		gameNumberKey = &GameNumberKey{
-			Key: "GAME",
-			Idx: 1,
+		return fmt.Errorf("gameInfo Marshal Error: %s", err.Error())
+	}
+
+	return ctx.GetStub().PutState(GameCompositeKey, gameInfoAsBytes)
+}
+
+// getHoleScore reads the scores of the hole
+// returns nil without an error when no score was set on the hole
+func getHoleScore(ctx contractapi.TransactionContextInterface, gameInfo *GameInfo, holeNumber string) (*HoleScore, error) {
+	// create composite key for the holeScore
+	HoleScoreCompositeKey, _ := ctx.GetStub().CreateCompositeKey("holeScore", []string{gameInfo.GameNumber, holeNumber})
+	holeScoreAsBytes, err := ctx.GetStub().GetState(HoleScoreCompositeKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if holeScoreAsBytes == nil {
+		return nil, nil
+	}
+
+	return unmarshalHoleScore(holeScoreAsBytes, gameInfo)
+}
+
+// putHoleScore writes the scores of the hole
+func putHoleScore(ctx contractapi.TransactionContextInterface, gameNumber string, holeScore *HoleScore) error {
+	// create composite key for the hole score
+	HoleScoreCompositeKey, _ := ctx.GetStub().CreateCompositeKey("holeScore", []string{gameNumber, holeScore.HoleNumber})
+	holeScoreAsBytes, err := json.Marshal(holeScore)
+	if err != nil {
+		return fmt.Errorf("holeScore Marshal Error: %s", err.Error())
+	}
+
+	return ctx.GetStub().PutState(HoleScoreCompositeKey, holeScoreAsBytes)
+}
+
+// getAgreement reads the agreement of the hole
+// returns nil without an error when no player has answered
+func getAgreement(ctx contractapi.TransactionContextInterface, gameInfo *GameInfo, holeNumber string) (*Agreement, error) {
+	// create composite key for the Agreement
+	AgreementCompositeKey, _ := ctx.GetStub().CreateCompositeKey("agreement", []string{gameInfo.GameNumber, holeNumber})
+	agreementAsBytes, err := ctx.GetStub().GetState(AgreementCompositeKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if agreementAsBytes == nil {
+		return nil, nil
+	}
+
+	return unmarshalAgreement(agreementAsBytes, gameInfo)
+}
+
+// unmarshalGameInfo reads a game, filling the players of a game written with User1ID..User4ID
+func unmarshalGameInfo(gameInfoAsBytes []byte) (*GameInfo, error) {
+	gameInfo := new(GameInfo)
+	err := json.Unmarshal(gameInfoAsBytes, gameInfo)
+	if err != nil {
+		return nil, fmt.Errorf("gameInfo Unmarshal Error: %s", err.Error())
+	}
+
+	if gameInfo.Players == nil {
+		legacy := new(fourPlayerRecord)
+		err = json.Unmarshal(gameInfoAsBytes, legacy)
+		if err != nil {
+			return nil, fmt.Errorf("gameInfo Unmarshal Error: %s", err.Error())
+		}
+
+		gameInfo.LegacySlots = []string{legacy.User1ID, legacy.User2ID, legacy.User3ID, legacy.User4ID}
+		gameInfo.Players = []string{}
+		for _, userID := range gameInfo.LegacySlots {
+			if userID != "" {
+				gameInfo.Players = append(gameInfo.Players, userID)
+			}
 		}
 	}
 
-	var tempIdx string
-	if !isFirst {
-		err = json.Unmarshal(gameNumberKeyAsBytes, &gameNumberKey)
+	// a game started before the lifecycle had four slots and was played as soon as it was ready
+	if gameInfo.Status == "" {
+		gameInfo.RosterSize = legacyRosterSize
+		gameInfo.Status = GameCreated
+		if gameInfo.IsReady {
+			gameInfo.Status = GameInProgress
+		}
+	}
+	if gameInfo.History == nil {
+		gameInfo.History = []*GameTransition{}
+	}
+
+	return gameInfo, nil
+}
+
+// unmarshalHoleScore reads the scores of a hole, converting the User1Score..User4Score of an old record
+// to the entries of the players in the same slots of the game.
+// A score stored as a string that is not a number is rejected with SCORE_OUT_OF_RANGE.
+func unmarshalHoleScore(holeScoreAsBytes []byte, gameInfo *GameInfo) (*HoleScore, error) {
+	holeScore := new(HoleScore)
+	err := json.Unmarshal(holeScoreAsBytes, holeScore)
+	if _, ok := err.(*GameError); ok {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("holeScore Unmarshal Error: %s", err.Error())
+	}
+
+	if holeScore.Scores == nil {
+		legacy := new(fourPlayerRecord)
+		err = json.Unmarshal(holeScoreAsBytes, legacy)
 		if err != nil {
-			fmt.Println(err.Error())
+			return nil, fmt.Errorf("holeScore Unmarshal Error: %s", err.Error())
+		}
+
+		holeScore.Scores = []*PlayerScore{}
+		for i, score := range []string{legacy.User1Score, legacy.User2Score, legacy.User3Score, legacy.User4Score} {
+			if score == "" || i >= len(gameInfo.LegacySlots) || gameInfo.LegacySlots[i] == "" {
+				continue
+			}
+			strokes, err := strconv.Atoi(score)
+			if err != nil {
+				return nil, newGameError(ErrCodeScoreOutOfRange, "the score %q of %s is not a number", score, gameInfo.LegacySlots[i])
+			}
+			holeScore.Scores = append(holeScore.Scores, &PlayerScore{UserID: gameInfo.LegacySlots[i], Score: strokes})
 		}
-		tempIdx = strconv.Itoa(gameNumberKey.Idx)
-		gameNumberKey.Idx = gameNumberKey.Idx + 1
 	}
 
-	fmt.Println("Last GameNumber is " + gameNumberKey.Key + " : " + tempIdx)
+	return holeScore, nil
+}
+
+// unmarshalAgreement reads the agreement of a hole, converting the User1Agree..User4Agree of an old record
+// to the entries of the players in the same slots of the game
+func unmarshalAgreement(agreementAsBytes []byte, gameInfo *GameInfo) (*Agreement, error) {
+	agreement := new(Agreement)
+	err := json.Unmarshal(agreementAsBytes, agreement)
+	if err != nil {
+		return nil, fmt.Errorf("agreement Unmarshal Error: %s", err.Error())
+	}
 
-	return gameNumberKey
+	if agreement.Agreements == nil {
+		legacy := new(fourPlayerRecord)
+		err = json.Unmarshal(agreementAsBytes, legacy)
+		if err != nil {
+			return nil, fmt.Errorf("agreement Unmarshal Error: %s", err.Error())
+		}
+
+		agreement.Agreements = []*PlayerAgreement{}
+		for i, agree := range []string{legacy.User1Agree, legacy.User2Agree, legacy.User3Agree, legacy.User4Agree} {
+			if agree == "" || i >= len(gameInfo.LegacySlots) || gameInfo.LegacySlots[i] == "" {
+				continue
+			}
+			agreement.Agreements = append(agreement.Agreements, &PlayerAgreement{UserID: gameInfo.LegacySlots[i], Agree: agree})
+		}
+	}
+
+	return agreement, nil
 }
 
 // main function
@@ -421,4 +576,4 @@ func main() {
 		fmt.Printf("Error starting fabcar chaincode: %s", err.Error())
 	}
 
-}
\ No newline at end of file
+}
@@ -0,0 +1,216 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// reservationChaincode is the name of the reservation chaincode on the channel, which keeps the course layouts
+const reservationChaincode = "reservation"
+
+// A game created before the course layout was kept is checked against 18 holes of par 5,
+// so a round in progress can still be scored.
+const (
+	legacyHoles = 18
+	legacyPar   = 5
+)
+
+// CourseLayout is the struct that informs the holes of the course a game is played on and the par of each.
+// It is copied from the reservation chaincode when the game is created.
+type CourseLayout struct {
+	CourseID string `json:"courseID"`
+	Holes    uint   `json:"holes"`
+	Pars     []uint `json:"pars"`
+}
+
+// ScoringRule is the struct that informs the highest score of a hole on a ground, double par plus ExtraStrokes
+type ScoringRule struct {
+	GroundID     string `json:"groundID"`
+	ExtraStrokes uint   `json:"extraStrokes"`
+}
+
+// SetScoringRule is the invoke function that sets the strokes allowed above double par on the ground.
+// Only the organization operating the ground in the reservation chaincode may set it.
+// params - ground ID, extra strokes
+func (s *SmartContract) SetScoringRule(ctx contractapi.TransactionContextInterface, groundID string, extraStrokes uint) error {
+	fmt.Println("SetScoringRule")
+
+	if groundID == "" {
+		return newGameError(ErrCodeInvalidRequest, "groundID must not be empty")
+	}
+
+	err := requireGroundOperator(ctx, groundID)
+	if err != nil {
+		return err
+	}
+
+	scoringRule := ScoringRule{
+		GroundID:     groundID,
+		ExtraStrokes: extraStrokes,
+	}
+
+	// create composite key for the scoring rule
+	ScoringRuleCompositeKey, _ := ctx.GetStub().CreateCompositeKey("scoringRule", []string{groundID})
+	scoringRuleAsBytes, err := json.Marshal(scoringRule)
+	if err != nil {
+		return fmt.Errorf("scoringRule Marshal Error: %s", err.Error())
+	}
+
+	return ctx.GetStub().PutState(ScoringRuleCompositeKey, scoringRuleAsBytes)
+}
+
+// QueryScoringRule is the query function that returns the scoring rule of the ground.
+// A ground without one allows double par.
+// params - ground ID
+// returns the ScoringRule
+func (s *SmartContract) QueryScoringRule(ctx contractapi.TransactionContextInterface, groundID string) (*ScoringRule, error) {
+	fmt.Println("QueryScoringRule")
+
+	return getScoringRule(ctx, groundID)
+}
+
+// getScoringRule reads the scoring rule of the ground, or the rule of double par when it has none
+func getScoringRule(ctx contractapi.TransactionContextInterface, groundID string) (*ScoringRule, error) {
+	ScoringRuleCompositeKey, _ := ctx.GetStub().CreateCompositeKey("scoringRule", []string{groundID})
+	scoringRuleAsBytes, err := ctx.GetStub().GetState(ScoringRuleCompositeKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	scoringRule := &ScoringRule{
+		GroundID: groundID,
+	}
+	if scoringRuleAsBytes == nil {
+		return scoringRule, nil
+	}
+
+	err = json.Unmarshal(scoringRuleAsBytes, scoringRule)
+	if err != nil {
+		return nil, fmt.Errorf("scoringRule Unmarshal Error: %s", err.Error())
+	}
+
+	return scoringRule, nil
+}
+
+// requireGroundOperator checks that the client belongs to the organization owning the ground in the reservation chaincode.
+// A ground without an owner may be operated by any organization, as in the reservation chaincode.
+func requireGroundOperator(ctx contractapi.TransactionContextInterface, groundID string) error {
+	args := [][]byte{[]byte("QueryGround"), []byte(groundID)}
+
+	response := ctx.GetStub().InvokeChaincode(reservationChaincode, args, "")
+	if response.Status != shim.OK {
+		return fmt.Errorf("Failed to read the ground %s. %s", groundID, response.Message)
+	}
+
+	var ground struct {
+		OwnerMSP string `json:"ownerMSP"`
+	}
+	err := json.Unmarshal(response.Payload, &ground)
+	if err != nil {
+		return fmt.Errorf("ground Unmarshal Error: %s", err.Error())
+	}
+
+	if ground.OwnerMSP == "" {
+		return nil
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("Failed to get the client identity. %s", err.Error())
+	}
+
+	if mspID != ground.OwnerMSP {
+		return newGameError(ErrCodeNotAuthorized, "%s is operated by %s, not %s", groundID, ground.OwnerMSP, mspID)
+	}
+
+	return nil
+}
+
+// queryCourseLayout reads the course of the ground from the reservation chaincode
+func queryCourseLayout(ctx contractapi.TransactionContextInterface, groundID, courseID string) (*CourseLayout, error) {
+	args := [][]byte{[]byte("QueryGroundCourses"), []byte(groundID)}
+
+	// an empty channel name reads the reservation chaincode on the channel of this transaction
+	response := ctx.GetStub().InvokeChaincode(reservationChaincode, args, "")
+	if response.Status != shim.OK {
+		return nil, fmt.Errorf("Failed to read the courses of %s. %s", groundID, response.Message)
+	}
+
+	var courses []*CourseLayout
+	err := json.Unmarshal(response.Payload, &courses)
+	if err != nil {
+		return nil, fmt.Errorf("course Unmarshal Error: %s", err.Error())
+	}
+
+	for _, course := range courses {
+		if course.CourseID == courseID {
+			if course.Holes == 0 || uint(len(course.Pars)) != course.Holes {
+				return nil, newGameError(ErrCodeCourseNotFound, "course %s of %s has no par for every hole", courseID, groundID)
+			}
+			return course, nil
+		}
+	}
+
+	return nil, newGameError(ErrCodeCourseNotFound, "%s has no course %s", groundID, courseID)
+}
+
+// checkHoleNumber checks the hole against the course of the game
+// returns the hole number without leading zeros and the par of the hole
+func (gameInfo *GameInfo) checkHoleNumber(holeNumber string) (string, uint, error) {
+	holes := uint(legacyHoles)
+	if gameInfo.Course != nil {
+		holes = gameInfo.Course.Holes
+	}
+
+	hole, err := strconv.Atoi(holeNumber)
+	if err != nil || hole < 1 || uint(hole) > holes {
+		return "", 0, newGameError(ErrCodeInvalidHole, "hole %s is not a hole from 1 to %d", holeNumber, holes)
+	}
+
+	par := uint(legacyPar)
+	if gameInfo.Course != nil {
+		par = gameInfo.Course.Pars[hole-1]
+	}
+
+	return strconv.Itoa(hole), par, nil
+}
+
+// UnmarshalJSON reads a score, which records written before scores were typed hold as a string.
+// A string that is not a number is rejected with SCORE_OUT_OF_RANGE.
+func (playerScore *PlayerScore) UnmarshalJSON(data []byte) error {
+	var record struct {
+		UserID string          `json:"userID"`
+		Score  json.RawMessage `json:"score"`
+	}
+	err := json.Unmarshal(data, &record)
+	if err != nil {
+		return err
+	}
+
+	playerScore.UserID = record.UserID
+	playerScore.Score = 0
+
+	var score string
+	if json.Unmarshal(record.Score, &score) == nil {
+		playerScore.Score, err = strconv.Atoi(score)
+		if err != nil {
+			return newGameError(ErrCodeScoreOutOfRange, "the score %q of %s is not a number", score, record.UserID)
+		}
+		return nil
+	}
+
+	if len(record.Score) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(record.Score, &playerScore.Score)
+}